@@ -1,5 +1,12 @@
 #!/usr/bin/bash
 
+# Use --keep-archive to keep the tar file in the "emails" directory instead of
+# extracting it. The indexer reads the archive directly, saving disk space
+keep_archive=false
+if [ "${1:-}" == "--keep-archive" ]; then
+  keep_archive=true
+fi
+
 # Determine what do to if the "emails" directory already exists
 if [ -d "emails" ]; then
   read -p "The 'emails' directory already exists. Do you want to delete it and its contents? (y/n) " confirm
@@ -14,7 +21,12 @@ fi
 # Download the tar file
 wget http://www.cs.cmu.edu/~enron/enron_mail_20110402.tgz
 
-# Extract the contents to a directory called "emails"
 mkdir emails
-tar -xvzf enron_mail_20110402.tgz -C emails/
-rm enron_mail_20110402.tgz
+if [ "$keep_archive" == "true" ]; then
+  # Move the tar file to the "emails" directory
+  mv enron_mail_20110402.tgz emails/
+else
+  # Extract the contents to a directory called "emails"
+  tar -xvzf enron_mail_20110402.tgz -C emails/
+  rm enron_mail_20110402.tgz
+fi
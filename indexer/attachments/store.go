@@ -0,0 +1,76 @@
+package attachments
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var hashRegex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// AttachmentStore stores the content of email attachments in a directory.
+// Contents are addressed by their sha256 hash, so duplicated attachments
+// are only stored once.
+type AttachmentStore struct {
+	Dir string
+}
+
+// NewAttachmentStore returns a new attachment store that saves the contents in dir.
+func NewAttachmentStore(dir string) *AttachmentStore {
+	return &AttachmentStore{
+		Dir: dir,
+	}
+}
+
+// path returns the path of the file that holds the content with the given hash.
+func (store *AttachmentStore) path(hash string) (string, error) {
+	if !hashRegex.MatchString(hash) {
+		return "", fmt.Errorf("invalid attachment hash: %v", hash)
+	}
+	return filepath.Join(store.Dir, hash[:2], hash), nil
+}
+
+// Save saves the content of an attachment with the given hash.
+// If the content is already stored, nothing is done.
+func (store *AttachmentStore) Save(hash string, content []byte) error {
+	path, err := store.path(hash)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// write to a temporary file first so a partial write is never served
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Open opens the content of the attachment with the given hash for reading.
+func (store *AttachmentStore) Open(hash string) (*os.File, error) {
+	path, err := store.path(hash)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("attachment %v not found: %w", hash, err)
+	}
+	return file, err
+}
@@ -0,0 +1,52 @@
+package email
+
+import (
+	"strings"
+)
+
+// Address represents a sender or a recipient of an email message.
+type Address struct {
+	Name    string `json:"name"`    // display name, may be empty
+	Address string `json:"address"` // email address, normalized to lowercase
+}
+
+// normalizeAddress normalizes an email address for indexing.
+func normalizeAddress(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
+
+// parseAddress parses the address of a single sender header (From). If the
+// value isn't a valid address, it's kept as the address so the sender isn't lost.
+func parseAddress(value string) Address {
+	list, err := addressParser.ParseList(value)
+	if err != nil || len(list) == 0 {
+		return Address{Address: normalizeAddress(decodeHeader(value))}
+	}
+	return Address{Name: list[0].Name, Address: normalizeAddress(list[0].Address)}
+}
+
+// parseAddressList parses the addresses of a recipients header (To, Cc, Bcc).
+func parseAddressList(value string) ([]Address, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	list, err := addressParser.ParseList(value)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]Address, len(list))
+	for i, addr := range list {
+		addresses[i] = Address{Name: addr.Name, Address: normalizeAddress(addr.Address)}
+	}
+	return addresses, nil
+}
+
+// addressesOf returns the email addresses of a list of senders or recipients.
+func addressesOf(list []Address) []string {
+	var addresses []string
+	for _, addr := range list {
+		addresses = append(addresses, addr.Address)
+	}
+	return addresses
+}
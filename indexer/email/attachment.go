@@ -0,0 +1,35 @@
+package email
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Attachment represents a file attached to an email message.
+// The content itself isn't JSON encoded, it's identified by its hash.
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Size        int    `json:"size"`
+	Hash        string `json:"hash"` // sha256 of the content (hex encoded)
+	Content     []byte `json:"-"`    // decoded content, only set by the parser
+}
+
+// attachmentFromPart creates an Attachment from a MIME part. The index n is
+// used to name the attachment if the part doesn't declare a filename.
+func attachmentFromPart(part *mimePart, n int) Attachment {
+	filename := part.filename()
+	if filename == "" {
+		filename = fmt.Sprintf("attachment-%d", n+1)
+	}
+	hash := sha256.Sum256(part.Content)
+
+	return Attachment{
+		Filename:    filename,
+		ContentType: part.MediaType,
+		Size:        len(part.Content),
+		Hash:        hex.EncodeToString(hash[:]),
+		Content:     part.Content,
+	}
+}
@@ -0,0 +1,66 @@
+package email
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// wordDecoder decodes RFC 2047 encoded-words in any of the charsets
+// known to the WHATWG Encoding Standard (iso-8859-*, windows-125*, koi8-r, gb2312, ...).
+var wordDecoder = &mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+
+// addressParser parses address lists whose display names have encoded-words.
+var addressParser = &mail.AddressParser{WordDecoder: wordDecoder}
+
+// decodeHeader decodes the encoded-words of a header value.
+// If the value can't be decoded, it is returned as is.
+func decodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// toUTF8 converts content declared in the given charset to UTF-8. If the charset
+// is missing or unknown, UTF-8 content is kept and anything else is assumed to be
+// windows-1252, the most common charset of legacy mail.
+func toUTF8(content []byte, label string) []byte {
+	label = strings.TrimSpace(label)
+	if label != "" {
+		reader, err := charset.NewReaderLabel(label, bytes.NewReader(content))
+		if err == nil {
+			if decoded, err := io.ReadAll(reader); err == nil {
+				return decoded
+			}
+		}
+	}
+	if utf8.Valid(content) {
+		return content
+	}
+
+	decoded, err := charmap.Windows1252.NewDecoder().Bytes(content)
+	if err != nil {
+		return bytes.ToValidUTF8(content, []byte("�"))
+	}
+	return decoded
+}
+
+// htmlToUTF8 converts html content to UTF-8, using the declared charset or,
+// if it's missing, the charset of the document's <meta> tags.
+func htmlToUTF8(content []byte, label string) []byte {
+	if strings.TrimSpace(label) == "" {
+		_, name, certain := charset.DetermineEncoding(content, "text/html")
+		if certain {
+			label = name
+		}
+	}
+	return toUTF8(content, label)
+}
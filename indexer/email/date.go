@@ -0,0 +1,137 @@
+package email
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The sources the date of an email can come from, from most to least reliable.
+const (
+	DateSourceHeader   = "date"         // a valid RFC 5322 Date header
+	DateSourceLenient  = "date-lenient" // a nonstandard Date header
+	DateSourceReceived = "received"     // the timestamp of a Received header
+	DateSourceModTime  = "mtime"        // the modification time of the source file
+	DateSourceNone     = "none"         // no date could be found
+)
+
+// lenientDateLayouts are the nonstandard Date layouts found in real archives.
+// They are tried after the timezone names and comments are normalized.
+var lenientDateLayouts = []string{
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"Mon, 2 Jan 06 15:04:05 -0700",
+	"Mon, 2-Jan-2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05",
+	"Mon, 2 Jan 2006 15:04",
+	"Mon 2 Jan 2006 15:04:05 -0700",
+	"Mon Jan 2 15:04:05 -0700 2006",
+	"Mon Jan 2 15:04:05 2006",
+	"Monday, January 2, 2006 3:04 PM",
+	"Monday, January 2, 2006 15:04:05 -0700",
+	"January 2, 2006 3:04 PM",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05",
+	"2 Jan 2006 15:04 -0700",
+	"2 Jan 06 15:04:05 -0700",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"01/02/2006 15:04:05 -0700",
+	"01/02/2006 15:04:05",
+	"01/02/2006 3:04 PM",
+	"01/02/2006",
+}
+
+// timezoneOffsets are the offsets of the timezone names found instead of
+// (or after) numeric offsets in nonstandard Date headers.
+var timezoneOffsets = map[string]string{
+	"UT": "+0000", "UTC": "+0000", "GMT": "+0000", "Z": "+0000", "WET": "+0000",
+	"EST": "-0500", "EDT": "-0400", "CST": "-0600", "CDT": "-0500",
+	"MST": "-0700", "MDT": "-0600", "PST": "-0800", "PDT": "-0700",
+	"AKST": "-0900", "AKDT": "-0800", "HST": "-1000",
+	"BST": "+0100", "CET": "+0100", "CEST": "+0200", "MET": "+0100", "MEST": "+0200",
+	"EET": "+0200", "EEST": "+0300", "MSK": "+0300", "JST": "+0900", "AEST": "+1000",
+}
+
+var (
+	dateCommentRegex  = regexp.MustCompile(`\([^)]*\)`)
+	dateTimezoneRegex = regexp.MustCompile(`\b([A-Za-z]{1,4})$`)
+	dateOffsetRegex   = regexp.MustCompile(`[+-]\d{4}\s+[+-]\d{4}$`)
+)
+
+// parseLenientDate parses a nonstandard Date header value. Comments and extra
+// whitespace are removed, and a trailing timezone name is replaced by its offset.
+func parseLenientDate(value string) (time.Time, error) {
+	value = dateCommentRegex.ReplaceAllString(value, "")
+	value = strings.Join(strings.Fields(value), " ")
+	if match := dateTimezoneRegex.FindStringSubmatch(value); match != nil {
+		if offset, ok := timezoneOffsets[strings.ToUpper(match[1])]; ok {
+			value = strings.TrimSpace(strings.TrimSuffix(value, match[1])) + " " + offset
+		}
+	}
+	// "... +0000 -0700" keeps the first offset, sent by the origin server
+	if dateOffsetRegex.MatchString(value) {
+		value = value[:len(value)-len(" -0700")]
+	}
+
+	for _, layout := range lenientDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown date format: %v", value)
+}
+
+// parseReceivedDate returns the timestamp of the earliest Received header
+// (the last one, added by the first server the message went through).
+func parseReceivedDate(header mail.Header) (time.Time, error) {
+	received := header["Received"]
+	for i := len(received) - 1; i >= 0; i-- {
+		// the timestamp comes after the last semicolon
+		semicolon := strings.LastIndex(received[i], ";")
+		if semicolon == -1 {
+			continue
+		}
+		value := strings.TrimSpace(received[i][semicolon+1:])
+		if date, err := mail.ParseDate(value); err == nil {
+			return date, nil
+		}
+		if date, err := parseLenientDate(value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("no valid Received header")
+}
+
+// parseDate returns the date of a message and the source it came from, falling
+// back through a nonstandard Date header, the Received headers and the
+// modification time of the source file (if it isn't zero).
+func parseDate(header mail.Header, modTime time.Time) (time.Time, string) {
+	if date, err := header.Date(); err == nil {
+		// net/mail accepts obsolete zone names (RFC 5322 obs-zone) but
+		// doesn't know their offsets, so they are parsed as UTC
+		name, offset := date.Zone()
+		if zoneOffset, ok := timezoneOffsets[name]; ok && offset == 0 && zoneOffset != "+0000" {
+			if fixed, err := parseLenientDate(header.Get("Date")); err == nil {
+				return fixed, DateSourceHeader
+			}
+		}
+		return date, DateSourceHeader
+	}
+	if value := header.Get("Date"); value != "" {
+		if date, err := parseLenientDate(value); err == nil {
+			return date, DateSourceLenient
+		}
+	}
+	if date, err := parseReceivedDate(header); err == nil {
+		return date, DateSourceReceived
+	}
+	if !modTime.IsZero() {
+		return modTime, DateSourceModTime
+	}
+	return time.Time{}, DateSourceNone
+}
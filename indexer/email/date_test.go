@@ -0,0 +1,124 @@
+package email
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	pdt := time.FixedZone("", -7*60*60)
+	modTime := time.Date(2002, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		header     string
+		modTime    time.Time
+		want       time.Time
+		wantSource string
+	}{
+		{
+			"rfc 5322",
+			"Date: Mon, 14 May 2001 16:39:00 -0700",
+			time.Time{}, time.Date(2001, time.May, 14, 16, 39, 0, 0, pdt), DateSourceHeader,
+		},
+		{
+			"trailing comment",
+			"Date: Mon, 14 May 2001 16:39:00 -0700 (PDT)",
+			time.Time{}, time.Date(2001, time.May, 14, 16, 39, 0, 0, pdt), DateSourceHeader,
+		},
+		{
+			"obs-zone gets its offset",
+			"Date: Mon, 14 May 2001 16:39:00 PDT",
+			time.Time{}, time.Date(2001, time.May, 14, 16, 39, 0, 0, pdt), DateSourceHeader,
+		},
+		{
+			"named timezone",
+			"Date: Mon, 14 May 2001 16:39:00 CEST",
+			time.Time{}, time.Date(2001, time.May, 14, 14, 39, 0, 0, time.UTC), DateSourceHeader,
+		},
+		{
+			"named timezone after the offset",
+			"Date: Mon, 14 May 2001 16:39:00 -0700 PDT",
+			time.Time{}, time.Date(2001, time.May, 14, 16, 39, 0, 0, pdt), DateSourceHeader,
+		},
+		{
+			"lowercase timezone with a comment",
+			"Date: Mon, 14 May 2001 16:39:00 (Pacific time) pdt",
+			time.Time{}, time.Date(2001, time.May, 14, 16, 39, 0, 0, pdt), DateSourceLenient,
+		},
+		{
+			"long names",
+			"Date: Monday, May 14, 2001 4:39 PM",
+			time.Time{}, time.Date(2001, time.May, 14, 16, 39, 0, 0, time.UTC), DateSourceLenient,
+		},
+		{
+			"iso without offset",
+			"Date: 2001-05-14 16:39:00",
+			time.Time{}, time.Date(2001, time.May, 14, 16, 39, 0, 0, time.UTC), DateSourceLenient,
+		},
+		{
+			"received fallback takes the earliest",
+			"Date: sometime in May\n" +
+				"Received: from b by c; Tue, 15 May 2001 10:00:00 -0700\n" +
+				"Received: from a by b; Mon, 14 May 2001 09:00:00 -0700",
+			modTime, time.Date(2001, time.May, 14, 9, 0, 0, 0, pdt), DateSourceReceived,
+		},
+		{
+			"received without a timestamp is skipped",
+			"Received: from b by c; Tue, 15 May 2001 10:00:00 -0700\n" +
+				"Received: from a by b",
+			modTime, time.Date(2001, time.May, 15, 10, 0, 0, 0, pdt), DateSourceReceived,
+		},
+		{
+			"modification time fallback",
+			"Date: unknown",
+			modTime, modTime, DateSourceModTime,
+		},
+		{
+			"no date",
+			"Subject: undated",
+			time.Time{}, time.Time{}, DateSourceNone,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, err := mail.ReadMessage(strings.NewReader(test.header + "\n\nbody"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, source := parseDate(msg.Header, test.modTime)
+			if !got.Equal(test.want) || source != test.wantSource {
+				t.Errorf("got %v from %v, want %v from %v", got, source, test.want, test.wantSource)
+			}
+		})
+	}
+}
+
+func TestParseLenientDate(t *testing.T) {
+	pdt := time.FixedZone("", -7*60*60)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"Mon, 14 May 2001 16:39:00 -0700 PDT", time.Date(2001, time.May, 14, 16, 39, 0, 0, pdt)},
+		{"Mon, 14 May 2001 23:39:00 +0000 -0700", time.Date(2001, time.May, 14, 23, 39, 0, 0, time.UTC)},
+		{"Mon,  14 May 2001 16:39  (comment)  -0700", time.Date(2001, time.May, 14, 16, 39, 0, 0, pdt)},
+		{"Mon, 14-May-2001 16:39:00 -0700", time.Date(2001, time.May, 14, 16, 39, 0, 0, pdt)},
+		{"Mon May 14 16:39:00 2001", time.Date(2001, time.May, 14, 16, 39, 0, 0, time.UTC)},
+		{"05/14/2001 4:39 PM", time.Date(2001, time.May, 14, 16, 39, 0, 0, time.UTC)},
+		{"14 May 2001 16:39:00 gmt", time.Date(2001, time.May, 14, 16, 39, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		got, err := parseLenientDate(test.value)
+		if err != nil || !got.Equal(test.want) {
+			t.Errorf("%q: got %v, %v, want %v", test.value, got, err, test.want)
+		}
+	}
+
+	if _, err := parseLenientDate("sometime in May"); err == nil {
+		t.Error("an unknown format was parsed")
+	}
+}
@@ -0,0 +1,54 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// The classes of errors a message can fail to parse with. There is deliberately no
+// bad date class: an invalid Date header never fails a message, which is indexed with
+// the date of a fallback source instead, recorded in its DateSource (see parseDate).
+const (
+	ErrorClassHeader  = "malformed-header" // the header section isn't valid RFC 5322
+	ErrorClassAddress = "bad-address-list" // a To, Cc or Bcc header isn't a valid address list
+	ErrorClassIO      = "io"               // the message couldn't be read
+	ErrorClassOther   = "other"
+)
+
+// ParseError is an error a message failed to parse with, with its class.
+type ParseError struct {
+	Class string
+	Err   error
+}
+
+func (err *ParseError) Error() string {
+	return fmt.Sprintf("%v: %v", err.Class, err.Err)
+}
+
+func (err *ParseError) Unwrap() error {
+	return err.Err
+}
+
+// ErrorClass returns the class of an error returned by the parser.
+func ErrorClass(err error) string {
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.Class
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return ErrorClassIO
+	}
+	return ErrorClassOther
+}
+
+// headerError classifies an error returned while reading the header section of a message.
+// A message that ends before its header does (io.EOF) is malformed, not unreadable.
+func headerError(err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return &ParseError{Class: ErrorClassIO, Err: err}
+	}
+	return &ParseError{Class: ErrorClassHeader, Err: err}
+}
@@ -0,0 +1,20 @@
+package email
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// DocumentId returns a stable id for the email, so indexing the same message
+// again produces the same document. It's a hash of the Message-ID and the source
+// of the message: its Maildir unique name (which doesn't change with its flags),
+// or the archive, path and offset it was read from.
+func (emailObj *Email) DocumentId() string {
+	source := emailObj.MaildirUniqueName
+	if source == "" {
+		source = fmt.Sprintf("%v:%v@%d", emailObj.SourceArchive, emailObj.SourcePath, emailObj.SourceOffset)
+	}
+	hash := sha256.Sum256([]byte(emailObj.MessageId + "\x00" + source))
+	return hex.EncodeToString(hash[:16])
+}
@@ -0,0 +1,59 @@
+package email
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maildirInfoSeparators separate the unique name of a Maildir file from its info.
+// The spec uses ':', but it's replaced by '!' on filesystems that don't allow it.
+const maildirInfoSeparators = ":!"
+
+// ParseMaildirName splits the name of a Maildir message file into its unique
+// name and its flags (the "2,FRS" info). Files without info have no flags.
+func ParseMaildirName(name string) (uniqueName string, flags string) {
+	separator := strings.LastIndexAny(name, maildirInfoSeparators)
+	if separator == -1 {
+		return name, ""
+	}
+	info := name[separator+1:]
+	if !strings.HasPrefix(info, "2,") {
+		return name[:separator], ""
+	}
+	return name[:separator], info[len("2,"):]
+}
+
+// SetMaildirSource sets the source of an email parsed from a Maildir message file,
+// located at path relative to the emails directory. Besides the source path, it sets
+// the Maildir unique name, the state of the email from the file flags, and the mailbox
+// and folder. The folder of Maildir++ directories (.Sent.2001) is turned into a path.
+func (emailObj *Email) SetMaildirSource(sourcePath string) {
+	emailObj.SourcePath = filepath.ToSlash(sourcePath)
+
+	var flags string
+	emailObj.MaildirUniqueName, flags = ParseMaildirName(filepath.Base(sourcePath))
+	emailObj.setMaildirFlags(flags)
+
+	// drop the cur/new directory, the mailbox and folder are above it
+	dirs := sourceDirs(sourcePath)
+	if n := len(dirs); n > 0 && (dirs[n-1] == "cur" || dirs[n-1] == "new") {
+		dirs = dirs[:n-1]
+	}
+	var subfolder string
+	if n := len(dirs); n > 0 && strings.HasPrefix(dirs[n-1], ".") {
+		subfolder = strings.ReplaceAll(strings.TrimPrefix(dirs[n-1], "."), ".", "/")
+		dirs = dirs[:n-1]
+	}
+	emailObj.setMailboxAndFolder(dirs)
+	emailObj.Folder = path.Join(emailObj.Folder, subfolder)
+}
+
+// setMaildirFlags sets the state of the email from the flags of its Maildir file.
+func (emailObj *Email) setMaildirFlags(flags string) {
+	emailObj.IsRead = strings.ContainsRune(flags, 'S')
+	emailObj.IsStarred = strings.ContainsRune(flags, 'F')
+	emailObj.IsReplied = strings.ContainsRune(flags, 'R')
+	emailObj.IsTrashed = strings.ContainsRune(flags, 'T')
+	emailObj.IsDraft = strings.ContainsRune(flags, 'D')
+}
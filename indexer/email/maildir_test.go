@@ -0,0 +1,79 @@
+package email
+
+import "testing"
+
+func TestParseMaildirName(t *testing.T) {
+	tests := []struct {
+		name           string
+		wantUniqueName string
+		wantFlags      string
+	}{
+		{"1000.M1P2.host:2,FRS", "1000.M1P2.host", "FRS"},
+		{"1000.M1P2.host!2,S", "1000.M1P2.host", "S"},
+		{"1000.M1P2.host:2,", "1000.M1P2.host", ""},
+		{"1000.M1P2.host", "1000.M1P2.host", ""},
+		{"1000.M1P2.host:1,experimental", "1000.M1P2.host", ""},
+	}
+
+	for _, test := range tests {
+		uniqueName, flags := ParseMaildirName(test.name)
+		if uniqueName != test.wantUniqueName || flags != test.wantFlags {
+			t.Errorf("%q: got %q and %q, want %q and %q", test.name, uniqueName, flags, test.wantUniqueName, test.wantFlags)
+		}
+	}
+}
+
+func TestSetMaildirSource(t *testing.T) {
+	tests := []struct {
+		sourcePath string
+		want       Email
+	}{
+		{
+			"lay-k/cur/1000.host:2,S",
+			Email{SourcePath: "lay-k/cur/1000.host:2,S", MaildirUniqueName: "1000.host", Mailbox: "lay-k", IsRead: true},
+		},
+		{
+			"lay-k/new/1000.host",
+			Email{SourcePath: "lay-k/new/1000.host", MaildirUniqueName: "1000.host", Mailbox: "lay-k"},
+		},
+		{
+			"lay-k/.Sent/cur/1000.host:2,RS",
+			Email{SourcePath: "lay-k/.Sent/cur/1000.host:2,RS", MaildirUniqueName: "1000.host", Mailbox: "lay-k", Folder: "Sent", IsRead: true, IsReplied: true},
+		},
+		{
+			"lay-k/.Projects.2001.Q2/cur/1000.host:2,DFT",
+			Email{SourcePath: "lay-k/.Projects.2001.Q2/cur/1000.host:2,DFT", MaildirUniqueName: "1000.host", Mailbox: "lay-k", Folder: "Projects/2001/Q2", IsStarred: true, IsTrashed: true, IsDraft: true},
+		},
+		{
+			"lay-k/archive/.Old/cur/1000.host!2,F",
+			Email{SourcePath: "lay-k/archive/.Old/cur/1000.host!2,F", MaildirUniqueName: "1000.host", Mailbox: "lay-k", Folder: "archive/Old", IsStarred: true},
+		},
+		{
+			"enron/maildir/lay-k/.Sent/new/1000.host",
+			Email{SourcePath: "enron/maildir/lay-k/.Sent/new/1000.host", MaildirUniqueName: "1000.host", Mailbox: "lay-k", Folder: "Sent"},
+		},
+		{
+			"cur/1000.host:2,S",
+			Email{SourcePath: "cur/1000.host:2,S", MaildirUniqueName: "1000.host", IsRead: true},
+		},
+	}
+
+	for _, test := range tests {
+		var got Email
+		got.SetMaildirSource(test.sourcePath)
+		if got.SourcePath != test.want.SourcePath || got.MaildirUniqueName != test.want.MaildirUniqueName ||
+			got.Mailbox != test.want.Mailbox || got.Folder != test.want.Folder {
+			t.Errorf("%q: got source %q, unique name %q, mailbox %q and folder %q, want %q, %q, %q and %q", test.sourcePath,
+				got.SourcePath, got.MaildirUniqueName, got.Mailbox, got.Folder,
+				test.want.SourcePath, test.want.MaildirUniqueName, test.want.Mailbox, test.want.Folder)
+		}
+		gotFlags := []bool{got.IsRead, got.IsStarred, got.IsReplied, got.IsTrashed, got.IsDraft}
+		wantFlags := []bool{test.want.IsRead, test.want.IsStarred, test.want.IsReplied, test.want.IsTrashed, test.want.IsDraft}
+		for i := range gotFlags {
+			if gotFlags[i] != wantFlags[i] {
+				t.Errorf("%q: got read, starred, replied, trashed and draft %v, want %v", test.sourcePath, gotFlags, wantFlags)
+				break
+			}
+		}
+	}
+}
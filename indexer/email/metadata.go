@@ -0,0 +1,55 @@
+package email
+
+import (
+	"net/mail"
+	"path/filepath"
+	"strings"
+)
+
+// maildirRoot is the directory that holds the mailboxes in the Enron corpus
+// layout: maildir/<mailbox>/<folder>/<file>.
+const maildirRoot = "maildir"
+
+// parseXHeaders sets the X-headers added by the Enron corpus export. They hold
+// the original (Lotus Notes / Outlook) names of the participants and folder.
+func (emailObj *Email) parseXHeaders(header mail.Header) {
+	emailObj.XFrom = decodeHeader(header.Get("X-From"))
+	emailObj.XTo = decodeHeader(header.Get("X-To"))
+	emailObj.XCc = decodeHeader(header.Get("X-cc"))
+	emailObj.XBcc = decodeHeader(header.Get("X-bcc"))
+	emailObj.XFolder = decodeHeader(header.Get("X-Folder"))
+	emailObj.XOrigin = decodeHeader(header.Get("X-Origin"))
+	emailObj.XFileName = decodeHeader(header.Get("X-FileName"))
+}
+
+// SetSource sets the path of the file the email was parsed from, relative to the
+// emails directory, and the mailbox and folder derived from it. If the path has a
+// maildir directory, the mailbox is the directory after it. Otherwise, it's the
+// first directory of the path. The folder is made of the directories left.
+func (emailObj *Email) SetSource(path string) {
+	emailObj.SourcePath = filepath.ToSlash(path)
+	emailObj.setMailboxAndFolder(sourceDirs(path))
+}
+
+// sourceDirs returns the directories of a source path, after the maildir directory if it has one.
+func sourceDirs(path string) []string {
+	dirs := strings.Split(filepath.ToSlash(filepath.Dir(path)), "/")
+	if dirs[0] == "." {
+		return nil
+	}
+	for i, dir := range dirs {
+		if dir == maildirRoot {
+			return dirs[i+1:]
+		}
+	}
+	return dirs
+}
+
+// setMailboxAndFolder sets the mailbox (first directory) and folder (the rest) of the email.
+func (emailObj *Email) setMailboxAndFolder(dirs []string) {
+	emailObj.Mailbox, emailObj.Folder = "", ""
+	if len(dirs) > 0 {
+		emailObj.Mailbox = dirs[0]
+		emailObj.Folder = strings.Join(dirs[1:], "/")
+	}
+}
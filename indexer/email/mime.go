@@ -0,0 +1,198 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// mimePart is a leaf part of a MIME message. Its content is already
+// decoded from the part's Content-Transfer-Encoding.
+type mimePart struct {
+	Header    textproto.MIMEHeader
+	MediaType string
+	Params    map[string]string
+	Content   []byte
+}
+
+// isAttachment returns true if the part was sent as an attachment
+// instead of being part of the message text.
+func (part *mimePart) isAttachment() bool {
+	disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	return disposition == "attachment"
+}
+
+// filename returns the filename declared by the part, if any.
+func (part *mimePart) filename() string {
+	_, params, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if params["filename"] != "" {
+		return decodeHeader(params["filename"])
+	}
+	return decodeHeader(part.Params["name"])
+}
+
+// walkParts walks the MIME tree of a message body and calls visit for every leaf part.
+// A malformed multipart body stops the walk, the parts visited until then are kept.
+func walkParts(header textproto.MIMEHeader, body io.Reader, visit func(part *mimePart)) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		// a missing or invalid content type defaults to plain text (RFC 2045)
+		mediaType = "text/plain"
+		params = map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := walkParts(part.Header, part, visit); err != nil {
+				return err
+			}
+		}
+	}
+
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	visit(&mimePart{
+		Header:    header,
+		MediaType: mediaType,
+		Params:    params,
+		Content:   decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), raw),
+	})
+	return nil
+}
+
+// decodeTransferEncoding decodes content encoded with the given Content-Transfer-Encoding.
+// If the content can't be decoded, it is returned as is.
+func decodeTransferEncoding(encoding string, content []byte) []byte {
+	var decoder io.Reader
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		decoder = quotedprintable.NewReader(bytes.NewReader(content))
+	case "base64":
+		decoder = base64.NewDecoder(base64.StdEncoding, bytes.NewReader(content))
+	default:
+		return content
+	}
+
+	decoded, err := io.ReadAll(decoder)
+	if err != nil {
+		return content
+	}
+	return decoded
+}
+
+// parseBody returns the readable text and the attachments of a message body.
+// The text is the first text/plain part, or the first text/html part converted
+// to text if the message has no plain text part. Any part that isn't message
+// text is returned as an attachment.
+func parseBody(header textproto.MIMEHeader, body []byte) (string, []Attachment) {
+	var plain, rich *mimePart
+	var attachments []Attachment
+	err := walkParts(header, bytes.NewReader(body), func(part *mimePart) {
+		// named text parts that come after the message text are attached files
+		isText := part.MediaType == "text/plain" || part.MediaType == "text/html"
+		isAttachedText := part.filename() != "" && (plain != nil || rich != nil)
+		if part.isAttachment() || !isText || isAttachedText {
+			attachments = append(attachments, attachmentFromPart(part, len(attachments)))
+			return
+		}
+		switch part.MediaType {
+		case "text/plain":
+			if plain == nil {
+				plain = part
+			}
+		case "text/html":
+			if rich == nil {
+				rich = part
+			}
+		}
+	})
+
+	switch {
+	case plain != nil:
+		return string(toUTF8(plain.Content, plain.Params["charset"])), attachments
+	case rich != nil:
+		return htmlToText(htmlToUTF8(rich.Content, rich.Params["charset"])), attachments
+	case err != nil:
+		// the MIME tree is broken and no text was found,
+		// fall back to the raw body so nothing is lost
+		return string(toUTF8(body, "")), attachments
+	}
+	return "", attachments
+}
+
+// blockElements are the html elements that are rendered on their own line.
+var blockElements = map[string]bool{
+	"address": true, "article": true, "blockquote": true, "br": true, "div": true,
+	"dl": true, "dt": true, "dd": true, "footer": true, "form": true, "h1": true,
+	"h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "header": true,
+	"hr": true, "li": true, "ol": true, "p": true, "pre": true, "section": true,
+	"table": true, "tr": true, "ul": true,
+}
+
+// skippedElements are the html elements whose content isn't readable text.
+var skippedElements = map[string]bool{
+	"head": true, "script": true, "style": true, "title": true,
+}
+
+var (
+	horizontalSpaceRegex = regexp.MustCompile(`[ \t\r\f\v\x{00a0}]+`)
+	blankLinesRegex      = regexp.MustCompile(`\n\s*\n\s*\n+`)
+)
+
+// htmlToText converts an html document to plain text, keeping the line
+// breaks of block elements and dropping scripts, styles and markup.
+func htmlToText(content []byte) string {
+	var text strings.Builder
+	skipping := 0
+
+	tokenizer := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			// io.EOF or a malformed document, return what was read
+			lines := strings.Split(horizontalSpaceRegex.ReplaceAllString(text.String(), " "), "\n")
+			for i, line := range lines {
+				lines[i] = strings.TrimSpace(line)
+			}
+			return strings.TrimSpace(blankLinesRegex.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			if skippedElements[string(name)] {
+				skipping++
+			}
+			if blockElements[string(name)] {
+				text.WriteString("\n")
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if skippedElements[string(name)] && skipping > 0 {
+				skipping--
+			}
+			if blockElements[string(name)] {
+				text.WriteString("\n")
+			}
+		case html.TextToken:
+			if skipping == 0 {
+				text.WriteString(strings.ReplaceAll(string(tokenizer.Text()), "\n", " "))
+			}
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package email
+
+import (
+	"io"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// attachmentSummary is the part of an attachment the tests check.
+type attachmentSummary struct {
+	Filename    string
+	ContentType string
+	Content     string
+}
+
+func TestParseBody(t *testing.T) {
+	tests := []struct {
+		name            string
+		message         string
+		wantBody        string
+		wantAttachments []attachmentSummary
+	}{
+		{
+			name:     "plain text without content type",
+			message:  "Subject: hi\n\nHello world\n",
+			wantBody: "Hello world\n",
+		},
+		{
+			name: "alternative prefers text/plain",
+			message: `Content-Type: multipart/alternative; boundary="b1"
+
+--b1
+Content-Type: text/html
+
+<p>Hello <b>html</b></p>
+--b1
+Content-Type: text/plain
+
+Hello plain
+--b1--
+`,
+			wantBody: "Hello plain",
+		},
+		{
+			name: "alternative without text/plain converts the html",
+			message: `Content-Type: multipart/alternative; boundary="b1"
+
+--b1
+Content-Type: text/html
+
+<html><head><title>skip</title></head><body><p>Hello</p><p>html &amp; more</p></body></html>
+--b1--
+`,
+			wantBody: "Hello\n\nhtml & more",
+		},
+		{
+			name: "nested mixed with an attachment",
+			message: `Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: multipart/alternative; boundary="inner"
+
+--inner
+Content-Type: text/plain
+
+Nested plain
+--inner
+Content-Type: text/html
+
+<p>Nested html</p>
+--inner--
+--outer
+Content-Type: application/pdf; name="report.pdf"
+Content-Disposition: attachment; filename="report.pdf"
+
+%PDF
+--outer--
+`,
+			wantBody:        "Nested plain",
+			wantAttachments: []attachmentSummary{{"report.pdf", "application/pdf", "%PDF"}},
+		},
+		{
+			name: "quoted-printable",
+			message: `Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+caf=C3=A9 with a soft=
+ break
+`,
+			wantBody: "café with a soft break\n",
+		},
+		{
+			name: "quoted-printable latin-1",
+			message: `Content-Type: text/plain; charset=iso-8859-1
+Content-Transfer-Encoding: quoted-printable
+
+caf=E9
+`,
+			wantBody: "café\n",
+		},
+		{
+			name: "base64",
+			message: `Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: base64
+
+SGVsbG8gYmFzZTY0
+`,
+			wantBody: "Hello base64",
+		},
+		{
+			name: "attachment without a filename",
+			message: `Content-Type: multipart/mixed; boundary="b1"
+
+--b1
+Content-Type: text/plain
+
+See attached
+--b1
+Content-Type: application/octet-stream
+Content-Transfer-Encoding: base64
+
+AAEC
+--b1
+Content-Type: image/png
+
+png
+--b1--
+`,
+			wantBody: "See attached",
+			wantAttachments: []attachmentSummary{
+				{"attachment-1", "application/octet-stream", "\x00\x01\x02"},
+				{"attachment-2", "image/png", "png"},
+			},
+		},
+		{
+			name: "named text after the message text is attached",
+			message: `Content-Type: multipart/mixed; boundary="b1"
+
+--b1
+Content-Type: text/plain
+
+The notes are attached
+--b1
+Content-Type: text/plain; name="notes.txt"
+
+notes
+--b1--
+`,
+			wantBody:        "The notes are attached",
+			wantAttachments: []attachmentSummary{{"notes.txt", "text/plain", "notes"}},
+		},
+		{
+			name: "encoded filename",
+			message: `Content-Type: multipart/mixed; boundary="b1"
+
+--b1
+Content-Type: text/plain
+
+body
+--b1
+Content-Type: application/pdf
+Content-Disposition: attachment; filename="=?utf-8?q?r=C3=A9sum=C3=A9.pdf?="
+
+pdf
+--b1--
+`,
+			wantBody:        "body",
+			wantAttachments: []attachmentSummary{{"résumé.pdf", "application/pdf", "pdf"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, err := mail.ReadMessage(strings.NewReader(test.message))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(msg.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotBody, attachments := parseBody(textproto.MIMEHeader(msg.Header), body)
+			if gotBody != test.wantBody {
+				t.Errorf("got body %q, want %q", gotBody, test.wantBody)
+			}
+			var gotAttachments []attachmentSummary
+			for _, attachment := range attachments {
+				gotAttachments = append(gotAttachments, attachmentSummary{attachment.Filename, attachment.ContentType, string(attachment.Content)})
+				if attachment.Size != len(attachment.Content) {
+					t.Errorf("%v: got size %v, want %v", attachment.Filename, attachment.Size, len(attachment.Content))
+				}
+			}
+			if len(gotAttachments) != len(test.wantAttachments) {
+				t.Fatalf("got attachments %q, want %q", gotAttachments, test.wantAttachments)
+			}
+			for i := range gotAttachments {
+				if gotAttachments[i] != test.wantAttachments[i] {
+					t.Errorf("attachment %d: got %q, want %q", i, gotAttachments[i], test.wantAttachments[i])
+				}
+			}
+		})
+	}
+}
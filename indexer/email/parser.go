@@ -1,24 +1,50 @@
 package email
 
 import (
-	"bytes"
+	"io"
 	"net/mail"
+	"net/textproto"
 	"os"
 	"time"
 )
 
 // Email represents an email message that can be JSON encoded.
+// From, To, Cc and Bcc only hold the normalized addresses, the display names
+// are kept in Sender and the Recipients lists.
 type Email struct {
-	MessageId string    `json:"messageId"`
-	Date      time.Time `json:"date"`
-	From      string    `json:"from"`
-	To        []string  `json:"to"`
-	Cc        []string  `json:"cc"`
-	Bcc       []string  `json:"bcc"`
-	Subject   string    `json:"subject"`
-	Body      string    `json:"body"`
-	IsRead    bool      `json:"isRead"`
-	IsStarred bool      `json:"isStarred"`
+	MessageId         string              `json:"messageId"`
+	Date              time.Time           `json:"date"`
+	DateSource        string              `json:"dateSource"` // where the date came from, see DateSource*
+	From              string              `json:"from"`
+	To                []string            `json:"to"`
+	Cc                []string            `json:"cc"`
+	Bcc               []string            `json:"bcc"`
+	Sender            Address             `json:"sender"`
+	ToRecipients      []Address           `json:"toRecipients"`
+	CcRecipients      []Address           `json:"ccRecipients"`
+	BccRecipients     []Address           `json:"bccRecipients"`
+	Subject           string              `json:"subject"`
+	Body              string              `json:"body"`
+	Attachments       []Attachment        `json:"attachments"`
+	Headers           map[string][]string `json:"headers"` // all the raw headers of the message
+	XFrom             string              `json:"xFrom"`
+	XTo               string              `json:"xTo"`
+	XCc               string              `json:"xCc"`
+	XBcc              string              `json:"xBcc"`
+	XFolder           string              `json:"xFolder"`
+	XOrigin           string              `json:"xOrigin"`
+	XFileName         string              `json:"xFileName"`
+	SourcePath        string              `json:"sourcePath"`        // relative to the emails directory (or archive)
+	SourceArchive     string              `json:"sourceArchive"`     // archive the source file was read from, if any
+	SourceOffset      int64               `json:"sourceOffset"`      // byte offset of the message in the source file (mbox)
+	Mailbox           string              `json:"mailbox"`           // owner of the mailbox the file came from
+	Folder            string              `json:"folder"`            // folder of the mailbox the file came from
+	MaildirUniqueName string              `json:"maildirUniqueName"` // stable name of a Maildir file, without its flags
+	IsRead            bool                `json:"isRead"`
+	IsStarred         bool                `json:"isStarred"`
+	IsReplied         bool                `json:"isReplied"`
+	IsTrashed         bool                `json:"isTrashed"`
+	IsDraft           bool                `json:"isDraft"`
 }
 
 // EmailFromFile parses an email file located at path to an Email struct for easy JSON encoding.
@@ -26,64 +52,59 @@ func EmailFromFile(path string) (*Email, error) {
 	// open the email file and read its contents
 	file, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return nil, &ParseError{Class: ErrorClassIO, Err: err}
 	}
 	defer file.Close()
 
+	info, err := file.Stat()
+	if err != nil {
+		return nil, &ParseError{Class: ErrorClassIO, Err: err}
+	}
+
+	return EmailFromReader(file, info.ModTime())
+}
+
+// EmailFromReader parses an email message read from r to an Email struct for easy JSON encoding.
+// The modification time of the message source is the last fallback for its date, it may be zero.
+func EmailFromReader(r io.Reader, modTime time.Time) (*Email, error) {
 	// parse the email
-	msg, err := mail.ReadMessage(file)
+	msg, err := mail.ReadMessage(r)
 	if err != nil {
-		return nil, err
+		return nil, headerError(err)
 	}
 
 	// convert the msg to a struct
 	emailObj := &Email{
 		MessageId: msg.Header.Get("Message-ID"),
-		From:      msg.Header.Get("From"),
-		Subject:   msg.Header.Get("Subject"),
+		Sender:    parseAddress(msg.Header.Get("From")),
+		Subject:   decodeHeader(msg.Header.Get("Subject")),
+		Headers:   msg.Header,
 		IsRead:    false,
 		IsStarred: false,
 	}
-	// parse the date
-	date, err := msg.Header.Date()
-	if err != nil {
-		return nil, err
+	emailObj.From = emailObj.Sender.Address
+	emailObj.parseXHeaders(msg.Header)
+	// parse the date, falling back to other sources if the Date header is invalid
+	emailObj.Date, emailObj.DateSource = parseDate(msg.Header, modTime)
+	// parse the To, Cc and Bcc headers (empty if they don't exist)
+	if emailObj.ToRecipients, err = parseAddressList(msg.Header.Get("To")); err != nil {
+		return nil, &ParseError{Class: ErrorClassAddress, Err: err}
 	}
-	emailObj.Date = date
-	// parse the To header if it exists
-	if msg.Header.Get("To") != "" {
-		to, err := msg.Header.AddressList("To")
-		if err != nil {
-			return nil, err
-		}
-		for _, addr := range to {
-			emailObj.To = append(emailObj.To, addr.Address)
-		}
+	if emailObj.CcRecipients, err = parseAddressList(msg.Header.Get("Cc")); err != nil {
+		return nil, &ParseError{Class: ErrorClassAddress, Err: err}
 	}
-	// parse the Cc header if it exists
-	if msg.Header.Get("Cc") != "" {
-		cc, err := msg.Header.AddressList("Cc")
-		if err != nil {
-			return nil, err
-		}
-		for _, addr := range cc {
-			emailObj.Cc = append(emailObj.Cc, addr.Address)
-		}
+	if emailObj.BccRecipients, err = parseAddressList(msg.Header.Get("Bcc")); err != nil {
+		return nil, &ParseError{Class: ErrorClassAddress, Err: err}
 	}
-	// parse the Bcc header if it exists
-	if msg.Header.Get("Bcc") != "" {
-		bcc, err := msg.Header.AddressList("Bcc")
-		if err != nil {
-			return nil, err
-		}
-		for _, addr := range bcc {
-			emailObj.Bcc = append(emailObj.Bcc, addr.Address)
-		}
+	emailObj.To = addressesOf(emailObj.ToRecipients)
+	emailObj.Cc = addressesOf(emailObj.CcRecipients)
+	emailObj.Bcc = addressesOf(emailObj.BccRecipients)
+	// parse the body, picking the most readable part of the MIME tree
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		return nil, &ParseError{Class: ErrorClassIO, Err: err}
 	}
-	// parse the body
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(msg.Body)
-	emailObj.Body = buf.String()
+	emailObj.Body, emailObj.Attachments = parseBody(textproto.MIMEHeader(msg.Header), body)
 
 	return emailObj, nil
 }
@@ -1,41 +1,112 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	_ "net/http/pprof"
 
+	"github.com/amoralesc/email-indexer/indexer/attachments"
 	"github.com/amoralesc/email-indexer/indexer/router"
 	"github.com/amoralesc/email-indexer/indexer/routines"
+	"github.com/amoralesc/email-indexer/indexer/store"
+	"github.com/amoralesc/email-indexer/indexer/store/blevestore"
+	"github.com/amoralesc/email-indexer/indexer/store/elastic"
+	"github.com/amoralesc/email-indexer/indexer/store/memory"
+	"github.com/amoralesc/email-indexer/indexer/store/sqlite"
 	"github.com/amoralesc/email-indexer/indexer/utils"
 	"github.com/amoralesc/email-indexer/indexer/zinc"
 )
 
 func main() {
 	// command line flags
-	index := flag.Bool("i", false, "Index the files in the emails directory (env EMAILS_DIR) to zinc.")
+	index := flag.Bool("i", false, "Index the files in the emails directory (env EMAILS_DIR) to zinc (or the email store of env EMAIL_STORE).")
 	server := flag.Bool("s", false, "Start the emails server (REST API).")
+	retryQuarantine := flag.Bool("q", false, "Retry the quarantined messages (that failed to parse) and upload the ones that parse.")
+	watch := flag.Bool("w", false, "Watch the emails directory and index the files as they are created, modified or deleted. Can be combined with -s.")
+	dryRun := flag.Bool("d", false, "Dry run: parse the files in the emails directory and report statistics, without connecting to zinc. Can't be combined with other flags.")
+	export := flag.Bool("e", false, "Export the parsed emails of the emails directory to an NDJSON file (env EXPORT_PATH), without connecting to zinc. The content of the attachments isn't exported, it's saved to ATTACHMENTS_DIR.")
+	importEmails := flag.Bool("l", false, "Load the emails of an NDJSON file exported with -e (env EXPORT_PATH) to zinc, without parsing them again.")
 	flag.Parse()
 
-	if !*index && !*server {
+	if !*index && !*server && !*retryQuarantine && !*watch && !*dryRun && !*export && !*importEmails {
 		log.Fatal("FATAL: at least one flag must be provided, use -h for help")
 	}
+	if *dryRun && (*index || *server || *retryQuarantine || *watch || *export || *importEmails) {
+		log.Fatal("FATAL: the dry run (-d) can't be combined with other flags")
+	}
+	// the store the emails are indexed to and served from: zinc, elastic (ELASTIC_URL),
+	// bleve (embedded, in BLEVE_INDEX_DIR), sqlite (embedded, in SQLITE_PATH), or memory
+	// (loaded from EXPORT_PATH, only served)
+	emailStoreType := utils.GetenvOrDefault("EMAIL_STORE", "zinc")
+	if emailStoreType != "zinc" && emailStoreType != "elastic" && emailStoreType != "bleve" && emailStoreType != "sqlite" && emailStoreType != "memory" {
+		log.Fatalf("FATAL: unknown email store %q, must be zinc, elastic, bleve, sqlite or memory", emailStoreType)
+	}
+	if emailStoreType == "memory" && (*index || *retryQuarantine || *watch || *importEmails) {
+		log.Fatal("FATAL: the memory email store is read from EXPORT_PATH, it can only be used by the server (-s)")
+	}
+
+	// SIGINT and SIGTERM cancel the indexing and shut the server down gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdownTimeout, _ := strconv.Atoi(utils.GetenvOrDefault("SHUTDOWN_TIMEOUT_SECONDS", "30"))
 
 	// check if profiling is enabled
 	enableProfiling, _ := strconv.ParseBool(utils.GetenvOrDefault("ENABLE_PROFILING", "false"))
-	// start zinc service with env vars
-	zinc.StartZincService(fmt.Sprintf("http://%v:%v", utils.GetenvOrDefault("ZINC_HOST", "localhost"), utils.GetenvOrDefault("ZINC_PORT", "4080")), utils.GetenvOrDefault("ZINC_ADMIN_USER", "admin"), utils.GetenvOrDefault("ZINC_ADMIN_PASSWORD", "Complexpass#123"))
+	// the attachment store, shared by the indexer (writes) and the server (reads)
+	attachmentStore := attachments.NewAttachmentStore(utils.GetenvOrDefault("ATTACHMENTS_DIR", "attachments"))
+
+	// the index the emails are uploaded to and served from (none for the memory store)
+	var emailIndex store.EmailIndex
+	var err error
+	switch emailStoreType {
+	case "zinc":
+		emailIndex = zinc.NewZincService(
+			fmt.Sprintf("http://%v:%v", utils.GetenvOrDefault("ZINC_HOST", "localhost"), utils.GetenvOrDefault("ZINC_PORT", "4080")),
+			utils.GetenvOrDefault("ZINC_ADMIN_USER", "admin"),
+			utils.GetenvOrDefault("ZINC_ADMIN_PASSWORD", "Complexpass#123"),
+		)
+	case "elastic":
+		emailIndex = elastic.NewElasticStore(
+			utils.GetenvOrDefault("ELASTIC_URL", "http://localhost:9200"),
+			utils.GetenvOrDefault("ELASTIC_USER", ""),
+			utils.GetenvOrDefault("ELASTIC_PASSWORD", ""),
+			utils.GetenvOrDefault("ELASTIC_INDEX", "emails"),
+		)
+	case "bleve":
+		bleveStore, err := blevestore.OpenBleveStore(utils.GetenvOrDefault("BLEVE_INDEX_DIR", "emails.bleve"))
+		if err != nil {
+			log.Fatal("FATAL: failed to open bleve index: ", err)
+		}
+		defer bleveStore.Close()
+		emailIndex = bleveStore
+	case "sqlite":
+		sqliteStore, err := sqlite.OpenSQLiteStore(utils.GetenvOrDefault("SQLITE_PATH", "emails.db"))
+		if err != nil {
+			log.Fatal("FATAL: failed to open sqlite database: ", err)
+		}
+		defer sqliteStore.Close()
+		emailIndex = sqliteStore
+	}
 
-	// check if index exists
+	// check if index exists (a dry run, export or server with the memory store doesn't need an index)
 	// if this fails, Zinc is down / not reachable and the program should exit
-	indexExists, err := zinc.Service.CheckIndex()
-	if err != nil {
-		log.Fatal("FATAL: failed to connect to zinc: ", err)
+	var indexExists bool
+	if *index || (*server && emailIndex != nil) || *retryQuarantine || *watch || *importEmails {
+		indexExists, err = emailIndex.CheckIndex(ctx)
+		if err != nil {
+			log.Fatalf("FATAL: failed to connect to %v: %v", emailStoreType, err)
+		}
 	}
 
 	// start profiling server on goroutine
@@ -47,6 +118,51 @@ func main() {
 		}()
 	}
 
+	// start indexing status server on goroutine (disabled if the port is 0)
+	statusPort := utils.GetenvOrDefault("STATUS_PORT", "3001")
+	if (*index || *retryQuarantine || *watch || *dryRun || *export || *importEmails) && statusPort != "0" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/status", routines.StatusHandler)
+		go func() {
+			log.Println("INFO: starting indexing status server on port", statusPort)
+			log.Println(http.ListenAndServe(fmt.Sprintf(":%v", statusPort), mux))
+		}()
+	}
+
+	// the directory where the indexer keeps its state between runs
+	stateDir := utils.GetenvOrDefault("INDEXER_STATE_DIR", "state")
+	// the manifest of the files indexed by the previous runs (incremental and watch modes)
+	manifestPath := filepath.Join(stateDir, "manifest.json")
+	// the checkpoint of a run that didn't finish, to resume it
+	checkpointPath := filepath.Join(stateDir, "checkpoint.ndjson")
+	// the NDJSON file the parsed emails are exported to, and imported from
+	exportPath := utils.GetenvOrDefault("EXPORT_PATH", "export/emails.ndjson.gz")
+
+	// parse the emails without uploading them
+	if *dryRun {
+		runDryRun(ctx, stateDir)
+		return
+	}
+
+	// export the parsed emails
+	if *export {
+		config := newIndexerConfig(stateDir, nil, attachmentStore)
+		config.Export, err = routines.CreateEmailWriter(exportPath)
+		if err != nil {
+			log.Fatal("FATAL: failed to create export: ", err)
+		}
+		log.Printf("INFO: starting to export emails at dir %v to %v", config.Dir, exportPath)
+		start := time.Now()
+		if err := routines.ExportEmails(ctx, config); err != nil {
+			log.Fatal("FATAL: failed to write export: ", err)
+		}
+		if ctx.Err() != nil {
+			log.Printf("INFO: exiting (export canceled after %v, the export is partial)", time.Since(start))
+			return
+		}
+		log.Printf("INFO: finished exporting %d emails in %v\n", config.Export.Written(), time.Since(start))
+	}
+
 	// index the emails
 	if *index {
 		// remove index if requested
@@ -54,7 +170,7 @@ func main() {
 		if removeIndex {
 			if indexExists {
 				log.Println("INFO: deleting emails index")
-				err := zinc.Service.DeleteIndex()
+				err := emailIndex.DeleteIndex(ctx)
 				if err != nil {
 					log.Panic("ERROR: failed to delete emails index:", err)
 				}
@@ -62,52 +178,289 @@ func main() {
 			}
 		}
 
-		// check if program should skip indexing
+		// in incremental mode, the manifest tracks the files indexed by the previous runs
+		incremental, _ := strconv.ParseBool(utils.GetenvOrDefault("INCREMENTAL_INDEXING", "false"))
+		resume := routines.HasCheckpoint(checkpointPath)
+
+		// check if program should skip indexing (never in incremental mode or when resuming)
 		preventUploadIfIndexExists, _ := strconv.ParseBool(utils.GetenvOrDefault("SKIP_UPLOAD_IF_INDEX_EXISTS", "true"))
-		if preventUploadIfIndexExists && indexExists {
+		if preventUploadIfIndexExists && indexExists && !incremental && !resume {
 			log.Println("INFO: emails index already exists, skipping upload")
 		} else {
 			// create index if it doesn't exist
 			if !indexExists {
-				log.Printf("INFO: creating emails index")
-				err := zinc.Service.CreateIndex()
-				if err != nil {
-					log.Fatal("FATAL: failed to create emails index: ", err)
-				}
+				createIndex(ctx, emailIndex, manifestPath, checkpointPath)
+				indexExists = true
 			}
 
-			// get env vars needed for indexing
 			emailsDir := utils.GetenvOrDefault("EMAILS_DIR", "emails")
-			zincAuth := &zinc.ZincAuth{
-				Url:      fmt.Sprintf("http://%v:%v", utils.GetenvOrDefault("ZINC_HOST", "localhost"), utils.GetenvOrDefault("ZINC_PORT", "4080")),
-				User:     utils.GetenvOrDefault("ZINC_ADMIN_USER", "admin"),
-				Password: utils.GetenvOrDefault("ZINC_ADMIN_PASSWORD", "Complexpass#123"),
+			config := newIndexerConfig(stateDir, emailIndex, attachmentStore)
+			if incremental {
+				config.Manifest, err = routines.LoadManifest(manifestPath)
+				if err != nil {
+					log.Fatal("FATAL: failed to load manifest: ", err)
+				}
+				log.Println("INFO: incremental indexing, manifest at:", manifestPath)
+			}
+			config.Checkpoint, err = routines.LoadCheckpoint(checkpointPath, emailsDir)
+			if err != nil {
+				log.Fatal("FATAL: failed to load checkpoint: ", err)
+			}
+			if config.Checkpoint.Len() > 0 {
+				log.Printf("INFO: resuming from checkpoint, skipping %d emails already uploaded", config.Checkpoint.Len())
 			}
-			numUploaderWorkers, _ := strconv.Atoi(utils.GetenvOrDefault("NUM_UPLOADER_WORKERS", "32"))
-			numParserWorkers, _ := strconv.Atoi(utils.GetenvOrDefault("NUM_PARSER_WORKERS", "128"))
-			bulkUploadSize, _ := strconv.Atoi(utils.GetenvOrDefault("BULK_UPLOAD_SIZE", "5000"))
 
 			log.Println("INFO: starting to parse and upload emails at dir:", emailsDir)
 			start := time.Now()
-			routines.ParseAndUploadEmails(emailsDir, numUploaderWorkers, numParserWorkers, bulkUploadSize, zincAuth)
+			routines.ParseAndUploadEmails(ctx, config)
+			if ctx.Err() != nil {
+				log.Printf("INFO: exiting (indexing canceled after %v)", time.Since(start))
+				return
+			}
 			log.Printf("INFO: finished uploading in %v\n", time.Since(start))
 
 			// sleep time after indexing
 			waitSeconds, _ := strconv.Atoi(utils.GetenvOrDefault("SLEEP_TIME_AFTER_INDEXING", "0"))
 			if waitSeconds > 0 {
 				log.Printf("INFO: sleeping for %v seconds", waitSeconds)
-				time.Sleep(time.Duration(waitSeconds) * time.Second)
+				select {
+				case <-time.After(time.Duration(waitSeconds) * time.Second):
+				case <-ctx.Done():
+				}
 			}
 		}
 	}
 
+	// import the exported emails
+	if *importEmails {
+		if !indexExists {
+			createIndex(ctx, emailIndex, manifestPath, checkpointPath)
+			indexExists = true
+		}
+		config := newIndexerConfig(stateDir, emailIndex, attachmentStore)
+		log.Println("INFO: starting to import emails from:", exportPath)
+		start := time.Now()
+		if err := routines.ImportEmails(ctx, config, exportPath); err != nil && ctx.Err() == nil {
+			log.Fatal("FATAL: failed to import emails: ", err)
+		}
+		if ctx.Err() != nil {
+			log.Printf("INFO: exiting (import canceled after %v)", time.Since(start))
+			return
+		}
+		log.Printf("INFO: finished importing in %v\n", time.Since(start))
+	}
+
+	// retry the quarantined messages
+	if *retryQuarantine {
+		if !indexExists {
+			log.Fatal("FATAL: emails index doesn't exist, index the emails first (-i)")
+		}
+		config := newIndexerConfig(stateDir, emailIndex, attachmentStore)
+		// the emails recovered are added to the files they were indexed from
+		config.Manifest, err = routines.LoadManifest(manifestPath)
+		if err != nil {
+			log.Fatal("FATAL: failed to load manifest: ", err)
+		}
+		start := time.Now()
+		routines.RetryQuarantine(ctx, config)
+		if ctx.Err() != nil {
+			log.Printf("INFO: exiting (retry canceled after %v)", time.Since(start))
+			return
+		}
+		log.Printf("INFO: finished retrying in %v\n", time.Since(start))
+	}
+
+	// watch the emails directory, alongside the server if requested
+	watchDone := make(chan struct{})
+	if *watch {
+		if !indexExists {
+			createIndex(ctx, emailIndex, manifestPath, checkpointPath)
+		}
+		config := newIndexerConfig(stateDir, emailIndex, attachmentStore)
+		config.Manifest, err = routines.LoadManifest(manifestPath)
+		if err != nil {
+			log.Fatal("FATAL: failed to load manifest: ", err)
+		}
+		debounce, _ := strconv.Atoi(utils.GetenvOrDefault("WATCH_DEBOUNCE_MS", "2000"))
+		watchEmails := func() {
+			defer close(watchDone)
+			err := routines.WatchEmails(ctx, config, time.Duration(debounce)*time.Millisecond)
+			if err != nil {
+				log.Fatal("FATAL: stopped watching emails: ", err)
+			}
+			log.Println("INFO: stopped watching emails")
+		}
+		if !*server {
+			watchEmails()
+			return
+		}
+		go watchEmails()
+	} else {
+		close(watchDone)
+	}
+
 	if !*server {
 		log.Printf("INFO: exiting (no server requested, use -s to start the server)")
 		return // exit with code 0
 	}
 
+	var emailStore store.EmailStore = emailIndex
+	if emailStoreType == "memory" {
+		emailStore = newMemoryStore(exportPath)
+	}
+
 	port := utils.GetenvOrDefault("API_PORT", "3000")
 	log.Println("INFO: starting REST API on port", port)
-	r := router.NewRouter()
-	http.ListenAndServe(fmt.Sprintf(":%v", port), r)
+	srv := &http.Server{Addr: fmt.Sprintf(":%v", port), Handler: router.NewRouter(emailStore, attachmentStore)}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("FATAL: failed to start REST API: ", err)
+		}
+	}()
+
+	// on signal, stop accepting requests and wait for the ones in progress
+	<-ctx.Done()
+	log.Printf("INFO: shutting down REST API (timeout %vs)", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(shutdownTimeout)*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("ERROR: failed to shut down REST API gracefully:", err)
+	}
+	<-watchDone
+}
+
+// newIndexerConfig returns the config of an indexing run from the env vars, which uploads
+// the emails to emailIndex and saves their attachments to attachmentStore.
+// The dead-letter queue, quarantine and run summary default to paths in stateDir.
+func newIndexerConfig(stateDir string, emailIndex store.EmailIndex, attachmentStore *attachments.AttachmentStore) *routines.IndexerConfig {
+	// get env vars needed for indexing
+	emailsDir := utils.GetenvOrDefault("EMAILS_DIR", "emails")
+	maildirMode, _ := strconv.ParseBool(utils.GetenvOrDefault("MAILDIR_MODE", "false"))
+	numUploaderWorkers, _ := strconv.Atoi(utils.GetenvOrDefault("NUM_UPLOADER_WORKERS", "32"))
+	numParserWorkers, _ := strconv.Atoi(utils.GetenvOrDefault("NUM_PARSER_WORKERS", "128"))
+	bulkUploadSize, _ := strconv.Atoi(utils.GetenvOrDefault("BULK_UPLOAD_SIZE", "5000"))
+	maxRetries, _ := strconv.Atoi(utils.GetenvOrDefault("UPLOAD_MAX_RETRIES", "5"))
+	initialBackoff, _ := strconv.Atoi(utils.GetenvOrDefault("UPLOAD_INITIAL_BACKOFF_MS", "500"))
+	maxBackoff, _ := strconv.Atoi(utils.GetenvOrDefault("UPLOAD_MAX_BACKOFF_MS", "30000"))
+	shutdownTimeout, _ := strconv.Atoi(utils.GetenvOrDefault("SHUTDOWN_TIMEOUT_SECONDS", "30"))
+	progressInterval, _ := strconv.Atoi(utils.GetenvOrDefault("PROGRESS_INTERVAL_SECONDS", "30"))
+	maxFileSize, _ := strconv.ParseInt(utils.GetenvOrDefault("MAX_FILE_SIZE_MB", "0"), 10, 64)
+
+	filter, err := routines.NewFileFilter(
+		splitList(utils.GetenvOrDefault("INCLUDE_PATTERNS", "")),
+		splitList(utils.GetenvOrDefault("EXCLUDE_PATTERNS", ".DS_Store,.gitkeep,Thumbs.db")),
+		maxFileSize*1024*1024,
+		splitList(utils.GetenvOrDefault("DENIED_FOLDERS", "")),
+	)
+	if err != nil {
+		log.Fatal("FATAL: invalid file filter: ", err)
+	}
+
+	quarantine, err := routines.LoadQuarantine(utils.GetenvOrDefault("QUARANTINE_DIR", filepath.Join(stateDir, "quarantine")))
+	if err != nil {
+		log.Fatal("FATAL: failed to load quarantine: ", err)
+	}
+
+	return &routines.IndexerConfig{
+		Dir:                emailsDir,
+		Maildir:            maildirMode,
+		Filter:             filter,
+		NumUploaderWorkers: numUploaderWorkers,
+		NumParserWorkers:   numParserWorkers,
+		BulkUploadSize:     bulkUploadSize,
+		Index:              emailIndex,
+		AttachmentStore:    attachmentStore,
+		Retry: routines.RetryConfig{
+			MaxRetries:     maxRetries,
+			InitialBackoff: time.Duration(initialBackoff) * time.Millisecond,
+			MaxBackoff:     time.Duration(maxBackoff) * time.Millisecond,
+		},
+		DeadLetters:      routines.NewDeadLetterQueue(utils.GetenvOrDefault("DEAD_LETTER_DIR", filepath.Join(stateDir, "dead-letter"))),
+		Quarantine:       quarantine,
+		ShutdownTimeout:  time.Duration(shutdownTimeout) * time.Second,
+		ProgressInterval: time.Duration(progressInterval) * time.Second,
+		SummaryPath:      utils.GetenvOrDefault("RUN_SUMMARY_PATH", filepath.Join(stateDir, "run-summary.json")),
+	}
+}
+
+// splitList splits a comma-separated list, trimming its items and dropping the empty ones.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// runDryRun parses the emails directory and reports statistics about it, without
+// uploading anything. The parsed emails are written to DRY_RUN_OUTPUT (if set).
+func runDryRun(ctx context.Context, stateDir string) {
+	// a dry run leaves the state of the indexer untouched
+	config := newIndexerConfig(stateDir, nil, nil)
+	config.DeadLetters = nil
+	config.Quarantine = nil
+	config.SummaryPath = ""
+
+	config.DryRun = routines.NewDryRun()
+	outputPath := utils.GetenvOrDefault("DRY_RUN_OUTPUT", "")
+	if outputPath != "" {
+		var err error
+		config.Export, err = routines.CreateEmailWriter(outputPath)
+		if err != nil {
+			log.Fatal("FATAL: failed to create dry run output: ", err)
+		}
+	}
+
+	log.Println("INFO: starting dry run at dir:", config.Dir)
+	start := time.Now()
+	report := routines.DryRunEmails(ctx, config)
+	if ctx.Err() != nil {
+		log.Printf("INFO: dry run canceled after %v, the report is partial", time.Since(start))
+	} else {
+		log.Printf("INFO: finished dry run in %v\n", time.Since(start))
+	}
+	if outputPath != "" {
+		log.Println("INFO: parsed emails written to:", outputPath)
+	}
+
+	if reportPath := utils.GetenvOrDefault("DRY_RUN_REPORT", ""); reportPath != "" {
+		if err := report.Save(reportPath); err != nil {
+			log.Fatal("FATAL: failed to save dry run report: ", err)
+		}
+		log.Println("INFO: dry run report saved to:", reportPath)
+	}
+}
+
+// createIndex creates the emails index. The manifest and checkpoint of a previous
+// index are removed, since the emails they have aren't indexed anymore.
+func createIndex(ctx context.Context, emailIndex store.EmailIndex, manifestPath string, checkpointPath string) {
+	log.Printf("INFO: creating emails index")
+	err := emailIndex.CreateIndex(ctx)
+	if err != nil {
+		log.Fatal("FATAL: failed to create emails index: ", err)
+	}
+	if err := os.Remove(manifestPath); err != nil && !os.IsNotExist(err) {
+		log.Fatal("FATAL: failed to remove manifest: ", err)
+	}
+	if err := routines.RemoveCheckpoint(checkpointPath); err != nil {
+		log.Fatal("FATAL: failed to remove checkpoint: ", err)
+	}
+}
+
+// newMemoryStore returns a memory store with the emails exported to exportPath (see -e).
+// If there's no export, the store is empty.
+func newMemoryStore(exportPath string) *memory.MemoryStore {
+	memoryStore := memory.NewMemoryStore()
+	count, err := memoryStore.LoadExport(exportPath)
+	if os.IsNotExist(err) {
+		log.Printf("WARN: export %v not found, the memory store is empty", exportPath)
+		return memoryStore
+	}
+	if err != nil {
+		log.Fatal("FATAL: failed to load export into the memory store: ", err)
+	}
+	log.Printf("INFO: loaded %v emails from %v into the memory store", count, exportPath)
+	return memoryStore
 }
@@ -0,0 +1,160 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+)
+
+var (
+	fromLinePrefix      = []byte("From ")
+	contentLengthPrefix = []byte("content-length:")
+)
+
+// Message is a message read from an mbox file.
+type Message struct {
+	Offset  int64  // byte offset of the message's From_ line in the mbox file
+	Content []byte // the RFC 5322 message, without the From_ line
+}
+
+// Reader splits an mbox file into messages. It supports the mboxrd format,
+// where From_ lines in the body are quoted with '>', and the mboxcl2 format,
+// where they aren't and the body size is given by a Content-Length header.
+type Reader struct {
+	r          *bufio.Reader
+	offset     int64  // offset of the next byte to read
+	fromLine   []byte // From_ line of the next message, if already read
+	fromOffset int64  // offset of fromLine
+}
+
+// NewReader returns a new Reader that reads messages from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// IsMbox returns true if the file located at path is an mbox file,
+// that is, if it starts with a From_ line.
+func IsMbox(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	prefix := make([]byte, len(fromLinePrefix))
+	if _, err := io.ReadFull(file, prefix); err != nil {
+		// files shorter than a From_ line aren't mbox files
+		return false, nil
+	}
+	return IsMboxContent(prefix), nil
+}
+
+// IsMboxContent returns true if content is the content of an mbox file,
+// that is, if it starts with a From_ line.
+func IsMboxContent(content []byte) bool {
+	return bytes.HasPrefix(content, fromLinePrefix)
+}
+
+// readLine reads a line, including its line ending.
+func (reader *Reader) readLine() ([]byte, error) {
+	line, err := reader.r.ReadBytes('\n')
+	reader.offset += int64(len(line))
+	if err == io.EOF && len(line) > 0 {
+		return line, nil
+	}
+	return line, err
+}
+
+// Next returns the next message of the mbox file, or io.EOF if there are no more messages.
+func (reader *Reader) Next() (*Message, error) {
+	// find the From_ line that starts the message
+	for reader.fromLine == nil {
+		offset := reader.offset
+		line, err := reader.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(line, fromLinePrefix) {
+			reader.fromLine, reader.fromOffset = line, offset
+		}
+	}
+	msg := &Message{Offset: reader.fromOffset}
+	reader.fromLine = nil
+
+	// read the header, looking for the mboxcl2 Content-Length
+	var content bytes.Buffer
+	contentLength := -1
+	for {
+		line, err := reader.readLine()
+		if err == io.EOF {
+			msg.Content = content.Bytes()
+			return msg, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		content.Write(line)
+		if isBlankLine(line) {
+			break
+		}
+		if bytes.HasPrefix(bytes.ToLower(line), contentLengthPrefix) {
+			length, err := strconv.Atoi(string(bytes.TrimSpace(line[len(contentLengthPrefix):])))
+			if err == nil && length >= 0 {
+				contentLength = length
+			}
+		}
+	}
+	headerLength := content.Len()
+
+	// read the body until the From_ line of the next message. With a Content-Length,
+	// From_ lines inside the body length belong to the body (mboxcl2). Without it,
+	// quoted From_ lines are unquoted (mboxrd).
+	for {
+		offset := reader.offset
+		line, err := reader.readLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(line, fromLinePrefix) && content.Len()-headerLength >= contentLength {
+			reader.fromLine, reader.fromOffset = line, offset
+			break
+		}
+		if contentLength < 0 {
+			line = unquoteFromLine(line)
+		}
+		content.Write(line)
+	}
+
+	// drop the blank line that separates the message from the next one
+	body := content.Bytes()
+	if len(body) > headerLength {
+		trimmed := bytes.TrimSuffix(body, []byte("\n"))
+		trimmed = bytes.TrimSuffix(trimmed, []byte("\r"))
+		if bytes.HasSuffix(trimmed, []byte("\n")) {
+			body = trimmed
+		} else if !bytes.HasSuffix(body, []byte("\n")) {
+			body = append(body, '\n')
+		}
+	}
+	msg.Content = body
+	return msg, nil
+}
+
+// isBlankLine returns true if the line has nothing but its line ending.
+func isBlankLine(line []byte) bool {
+	return len(bytes.TrimRight(line, "\r\n")) == 0
+}
+
+// unquoteFromLine removes one level of mboxrd quoting from a ">From " line.
+func unquoteFromLine(line []byte) []byte {
+	quoted := bytes.TrimLeft(line, ">")
+	if len(quoted) < len(line) && bytes.HasPrefix(quoted, fromLinePrefix) {
+		return line[1:]
+	}
+	return line
+}
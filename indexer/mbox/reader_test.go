@@ -0,0 +1,156 @@
+package mbox
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readAll returns the messages of an mbox file.
+func readAll(t *testing.T, content string) []Message {
+	t.Helper()
+	var messages []Message
+	reader := NewReader(strings.NewReader(content))
+	for {
+		msg, err := reader.Next()
+		if err == io.EOF {
+			return messages
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, Message{Offset: msg.Offset, Content: msg.Content})
+	}
+}
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		name  string
+		mbox  string
+		want  []string // the content of the messages
+		froms []string // the From_ lines of the messages, to check their offsets
+	}{
+		{
+			name: "mboxrd unquotes one level",
+			mbox: "From a@x Mon May 14 16:39:00 2001\n" +
+				"Subject: one\n\n" +
+				">From the start\n" +
+				">>From quoted twice\n" +
+				">Fromage isn't a From_ line\n" +
+				"\n" +
+				"From b@x Mon May 14 16:40:00 2001\n" +
+				"Subject: two\n\n" +
+				"last\n",
+			want: []string{
+				"Subject: one\n\nFrom the start\n>From quoted twice\n>Fromage isn't a From_ line\n",
+				"Subject: two\n\nlast\n",
+			},
+			froms: []string{"From a@x", "From b@x"},
+		},
+		{
+			name: "mboxcl2 keeps the From_ lines inside the content length",
+			mbox: "From a@x Mon May 14 16:39:00 2001\n" +
+				"Subject: one\n" +
+				"Content-Length: 27\n\n" +
+				"From here on\n" +
+				">From is kept\n" +
+				"\n" +
+				"From b@x Mon May 14 16:40:00 2001\n" +
+				"Subject: two\n\n" +
+				"last\n",
+			want: []string{
+				"Subject: one\nContent-Length: 27\n\nFrom here on\n>From is kept\n",
+				"Subject: two\n\nlast\n",
+			},
+			froms: []string{"From a@x", "From b@x"},
+		},
+		{
+			name: "invalid content length is mboxrd",
+			mbox: "From a@x Mon May 14 16:39:00 2001\n" +
+				"Content-Length: many\n\n" +
+				">From quoted\n" +
+				"From b@x Mon May 14 16:40:00 2001\n" +
+				"\n",
+			want: []string{
+				"Content-Length: many\n\nFrom quoted\n",
+				"\n",
+			},
+			froms: []string{"From a@x", "From b@x"},
+		},
+		{
+			name: "lines before the first From_ line are skipped",
+			mbox: "garbage\n\n" +
+				"From a@x Mon May 14 16:39:00 2001\n" +
+				"Subject: one\n\n" +
+				"body without a final newline",
+			want:  []string{"Subject: one\n\nbody without a final newline\n"},
+			froms: []string{"From a@x"},
+		},
+		{
+			name: "crlf line endings",
+			mbox: "From a@x Mon May 14 16:39:00 2001\r\n" +
+				"Subject: one\r\n\r\n" +
+				">From crlf\r\n" +
+				"\r\n" +
+				"From b@x Mon May 14 16:40:00 2001\r\n" +
+				"Subject: two\r\n\r\n" +
+				"last\r\n",
+			want: []string{
+				"Subject: one\r\n\r\nFrom crlf\r\n",
+				"Subject: two\r\n\r\nlast\r\n",
+			},
+			froms: []string{"From a@x", "From b@x"},
+		},
+		{
+			name: "header without a body",
+			mbox: "From a@x Mon May 14 16:39:00 2001\n" +
+				"Subject: one\n",
+			want:  []string{"Subject: one\n"},
+			froms: []string{"From a@x"},
+		},
+		{
+			name: "no From_ line",
+			mbox: "Subject: not an mbox\n\nbody\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			messages := readAll(t, test.mbox)
+			var got []string
+			var offsets, wantOffsets []int64
+			for _, msg := range messages {
+				got = append(got, string(msg.Content))
+				offsets = append(offsets, msg.Offset)
+			}
+			for _, from := range test.froms {
+				wantOffsets = append(wantOffsets, int64(strings.Index(test.mbox, from)))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got messages %q, want %q", got, test.want)
+			}
+			if !reflect.DeepEqual(offsets, wantOffsets) {
+				t.Errorf("got offsets %v, want %v", offsets, wantOffsets)
+			}
+		})
+	}
+}
+
+func TestIsMboxContent(t *testing.T) {
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"From a@x Mon May 14 16:39:00 2001\n", true},
+		{"From: a@x\n", false},
+		{">From a@x\n", false},
+		{"Fro", false},
+	}
+
+	for _, test := range tests {
+		if got := IsMboxContent([]byte(test.content)); got != test.want {
+			t.Errorf("%q: got %v, want %v", test.content, got, test.want)
+		}
+	}
+}
@@ -7,11 +7,11 @@ import (
 	"net/http"
 	"strconv"
 
-	"github.com/amoralesc/email-indexer/indexer/zinc"
+	"github.com/amoralesc/email-indexer/indexer/store"
 	"github.com/go-chi/render"
 )
 
-// loadQuerySettings is a middleware that loads the zinc.QuerySettings
+// loadQuerySettings is a middleware that loads the store.QuerySettings
 // from the query parameters and adds them as context values.
 func loadQuerySettings(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -53,7 +53,7 @@ func loadQuerySettings(next http.Handler) http.Handler {
 		}
 
 		// create the query settings
-		querySettings, err := zinc.NewQuerySettings(sortBy, startInt, sizeInt, starredOnlyBool)
+		querySettings, err := store.NewQuerySettings(sortBy, startInt, sizeInt, starredOnlyBool)
 		if err != nil {
 			log.Printf("ERROR: %v\n", err)
 			render.Render(w, r, ErrInvalidRequest(err))
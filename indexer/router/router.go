@@ -1,21 +1,36 @@
 package router
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/amoralesc/email-indexer/indexer/attachments"
 	"github.com/amoralesc/email-indexer/indexer/email"
-	"github.com/amoralesc/email-indexer/indexer/zinc"
+	"github.com/amoralesc/email-indexer/indexer/store"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/go-chi/render"
 )
 
-// NewRouter creates a new router
-func NewRouter() http.Handler {
+// EmailsHandler handles the requests of the emails API, with the emails of a store
+// and the content of their attachments.
+type EmailsHandler struct {
+	Store           store.EmailStore
+	AttachmentStore *attachments.AttachmentStore
+}
+
+// NewRouter creates a new router, serving the emails of emailStore and the content
+// of their attachments from attachmentStore.
+func NewRouter(emailStore store.EmailStore, attachmentStore *attachments.AttachmentStore) http.Handler {
+	handler := &EmailsHandler{Store: emailStore, AttachmentStore: attachmentStore}
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
@@ -29,18 +44,19 @@ func NewRouter() http.Handler {
 	}))
 
 	r.Route("/api/emails", func(r chi.Router) {
-		r.With(loadQuerySettings).Get("/", ListEmails)
-		r.Put("/", UpdateEmails)
-		r.Delete("/", DeleteEmails)
-		r.With(loadQuerySettings).Post("/search", SearchEmails)
-		r.With(loadQuerySettings).Get("/query", QueryEmails)
+		r.With(loadQuerySettings).Get("/", handler.ListEmails)
+		r.Put("/", handler.UpdateEmails)
+		r.Delete("/", handler.DeleteEmails)
+		r.With(loadQuerySettings).Post("/search", handler.SearchEmails)
+		r.With(loadQuerySettings).Get("/query", handler.QueryEmails)
 		r.Route("/{emailId}", func(r chi.Router) {
-			r.Get("/", GetEmailById)
-			r.Put("/", UpdateEmail)
-			r.Delete("/", DeleteEmail)
+			r.Get("/", handler.GetEmailById)
+			r.Put("/", handler.UpdateEmail)
+			r.Delete("/", handler.DeleteEmail)
+			r.Get("/attachments/{n}", handler.GetEmailAttachment)
 		})
 		r.Route("/messageId/{messageId}", func(r chi.Router) {
-			r.Get("/", GetEmailByMessageId)
+			r.Get("/", handler.GetEmailByMessageId)
 		})
 	})
 
@@ -48,9 +64,9 @@ func NewRouter() http.Handler {
 }
 
 // ListEmails returns a list of all emails in zinc.
-func ListEmails(w http.ResponseWriter, r *http.Request) {
-	querySettings := r.Context().Value("querySettings").(*zinc.QuerySettings)
-	resp, err := zinc.Service.GetAllEmails(querySettings)
+func (handler *EmailsHandler) ListEmails(w http.ResponseWriter, r *http.Request) {
+	querySettings := r.Context().Value("querySettings").(*store.QuerySettings)
+	resp, err := handler.Store.GetAllEmails(r.Context(), querySettings)
 
 	if err != nil {
 		log.Printf("ERROR: %v\n", err)
@@ -68,8 +84,8 @@ func ListEmails(w http.ResponseWriter, r *http.Request) {
 }
 
 // UpdateEmails updates multiple emails.
-func UpdateEmails(w http.ResponseWriter, r *http.Request) {
-	var emails []*zinc.EmailWithId
+func (handler *EmailsHandler) UpdateEmails(w http.ResponseWriter, r *http.Request) {
+	var emails []*store.EmailWithId
 
 	// get the emails from the body
 	if err := render.DecodeJSON(r.Body, &emails); err != nil {
@@ -83,7 +99,7 @@ func UpdateEmails(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := zinc.Service.UpdateEmails(emails)
+	resp, err := handler.Store.UpdateEmails(r.Context(), emails)
 
 	if err != nil {
 		log.Printf("ERROR: %v\n", err)
@@ -102,9 +118,9 @@ func UpdateEmails(w http.ResponseWriter, r *http.Request) {
 
 // SearchEmails returns a list of emails that match the search query.
 // The search query comes from the body of the request as a JSON object.
-func SearchEmails(w http.ResponseWriter, r *http.Request) {
-	querySettings := r.Context().Value("querySettings").(*zinc.QuerySettings)
-	var searchQuery *zinc.SearchQuery
+func (handler *EmailsHandler) SearchEmails(w http.ResponseWriter, r *http.Request) {
+	querySettings := r.Context().Value("querySettings").(*store.QuerySettings)
+	var searchQuery *store.SearchQuery
 
 	// get the search query from the body
 	if err := render.DecodeJSON(r.Body, &searchQuery); err != nil {
@@ -118,7 +134,7 @@ func SearchEmails(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := zinc.Service.GetEmailsBySearchQuery(searchQuery, querySettings)
+	resp, err := handler.Store.GetEmailsBySearchQuery(r.Context(), searchQuery, querySettings)
 
 	if err != nil {
 		log.Printf("ERROR: %v\n", err)
@@ -137,15 +153,15 @@ func SearchEmails(w http.ResponseWriter, r *http.Request) {
 
 // QueryEmails returns a list of emails that match the query string.
 // The query string comes as a query parameter.
-func QueryEmails(w http.ResponseWriter, r *http.Request) {
-	querySettings := r.Context().Value("querySettings").(*zinc.QuerySettings)
+func (handler *EmailsHandler) QueryEmails(w http.ResponseWriter, r *http.Request) {
+	querySettings := r.Context().Value("querySettings").(*store.QuerySettings)
 	queryString := r.URL.Query().Get("q")
 	if queryString == "" {
 		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("query string can't be empty")))
 		return
 	}
 
-	resp, err := zinc.Service.GetEmailsByQueryString(queryString, querySettings)
+	resp, err := handler.Store.GetEmailsByQueryString(r.Context(), queryString, querySettings)
 
 	if err != nil {
 		log.Printf("ERROR: %v\n", err)
@@ -163,8 +179,8 @@ func QueryEmails(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetEmailById returns an email by its id.
-func GetEmailById(w http.ResponseWriter, r *http.Request) {
-	resp, err := zinc.Service.GetEmailById(chi.URLParam(r, "emailId"))
+func (handler *EmailsHandler) GetEmailById(w http.ResponseWriter, r *http.Request) {
+	resp, err := handler.Store.GetEmailById(r.Context(), chi.URLParam(r, "emailId"))
 
 	if err != nil {
 		log.Printf("ERROR: %v\n", err)
@@ -172,7 +188,7 @@ func GetEmailById(w http.ResponseWriter, r *http.Request) {
 			render.Render(w, r, ErrServiceUnavailable)
 			return
 		}
-		if strings.Contains(err.Error(), "id not found") {
+		if errors.Is(err, store.ErrNotFound) {
 			render.Render(w, r, ErrNotFound)
 			return
 		}
@@ -186,8 +202,8 @@ func GetEmailById(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetEmailByMessageId returns an email by its message id.
-func GetEmailByMessageId(w http.ResponseWriter, r *http.Request) {
-	resp, err := zinc.Service.GetEmailByMessageId(chi.URLParam(r, "messageId"))
+func (handler *EmailsHandler) GetEmailByMessageId(w http.ResponseWriter, r *http.Request) {
+	resp, err := handler.Store.GetEmailByMessageId(r.Context(), chi.URLParam(r, "messageId"))
 
 	if err != nil {
 		log.Printf("ERROR: %v\n", err)
@@ -195,7 +211,7 @@ func GetEmailByMessageId(w http.ResponseWriter, r *http.Request) {
 			render.Render(w, r, ErrServiceUnavailable)
 			return
 		}
-		if strings.Contains(err.Error(), "id not found") {
+		if errors.Is(err, store.ErrNotFound) {
 			render.Render(w, r, ErrNotFound)
 			return
 		}
@@ -208,8 +224,62 @@ func GetEmailByMessageId(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, resp)
 }
 
+// GetEmailAttachment returns the content of the n-th attachment (0 based) of an email.
+// The content is sent as is, with the attachment's content type and filename.
+func (handler *EmailsHandler) GetEmailAttachment(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(chi.URLParam(r, "n"))
+	if err != nil || n < 0 {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("attachment number should be a non negative integer")))
+		return
+	}
+
+	emailWithId, err := handler.Store.GetEmailById(r.Context(), chi.URLParam(r, "emailId"))
+
+	if err != nil {
+		log.Printf("ERROR: %v\n", err)
+		if strings.Contains(err.Error(), "connection refused") {
+			render.Render(w, r, ErrServiceUnavailable)
+			return
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+
+		render.Render(w, r, ErrInternalServer)
+		return
+	}
+	if n >= len(emailWithId.Attachments) {
+		render.Render(w, r, ErrNotFound)
+		return
+	}
+	attachment := emailWithId.Attachments[n]
+
+	file, err := handler.AttachmentStore.Open(attachment.Hash)
+
+	if err != nil {
+		log.Printf("ERROR: %v\n", err)
+		if errors.Is(err, fs.ErrNotExist) {
+			render.Render(w, r, ErrNotFound)
+			return
+		}
+
+		render.Render(w, r, ErrInternalServer)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(attachment.Size))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, file); err != nil {
+		log.Printf("ERROR: %v\n", err)
+	}
+}
+
 // UpdateEmail updates an email by its id.
-func UpdateEmail(w http.ResponseWriter, r *http.Request) {
+func (handler *EmailsHandler) UpdateEmail(w http.ResponseWriter, r *http.Request) {
 	var email *email.Email
 
 	// get the email from the body
@@ -224,7 +294,7 @@ func UpdateEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := zinc.Service.UpdateEmail(chi.URLParam(r, "emailId"), email)
+	resp, err := handler.Store.UpdateEmail(r.Context(), chi.URLParam(r, "emailId"), email)
 
 	if err != nil {
 		log.Printf("ERROR: %v\n", err)
@@ -232,7 +302,7 @@ func UpdateEmail(w http.ResponseWriter, r *http.Request) {
 			render.Render(w, r, ErrServiceUnavailable)
 			return
 		}
-		if strings.Contains(err.Error(), "id not found") {
+		if errors.Is(err, store.ErrNotFound) {
 			render.Render(w, r, ErrNotFound)
 			return
 		}
@@ -246,8 +316,8 @@ func UpdateEmail(w http.ResponseWriter, r *http.Request) {
 }
 
 // DeleteEmail deletes an email by its id.
-func DeleteEmail(w http.ResponseWriter, r *http.Request) {
-	err := zinc.Service.DeleteEmail(chi.URLParam(r, "emailId"))
+func (handler *EmailsHandler) DeleteEmail(w http.ResponseWriter, r *http.Request) {
+	err := handler.Store.DeleteEmail(r.Context(), chi.URLParam(r, "emailId"))
 
 	if err != nil {
 		log.Printf("ERROR: %v\n", err)
@@ -255,7 +325,7 @@ func DeleteEmail(w http.ResponseWriter, r *http.Request) {
 			render.Render(w, r, ErrServiceUnavailable)
 			return
 		}
-		if strings.Contains(err.Error(), "id not found") {
+		if errors.Is(err, store.ErrNotFound) {
 			render.Render(w, r, ErrNotFound)
 			return
 		}
@@ -268,7 +338,7 @@ func DeleteEmail(w http.ResponseWriter, r *http.Request) {
 }
 
 // DeleteEmails deletes emails by their ids.
-func DeleteEmails(w http.ResponseWriter, r *http.Request) {
+func (handler *EmailsHandler) DeleteEmails(w http.ResponseWriter, r *http.Request) {
 	// get the ids from the query param ids
 	ids := r.URL.Query().Get("ids")
 	if ids == "" {
@@ -276,7 +346,7 @@ func DeleteEmails(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := zinc.Service.DeleteEmails(strings.Split(ids, ","))
+	err := handler.Store.DeleteEmails(r.Context(), strings.Split(ids, ","))
 
 	if err != nil {
 		log.Printf("ERROR: %v\n", err)
@@ -284,7 +354,7 @@ func DeleteEmails(w http.ResponseWriter, r *http.Request) {
 			render.Render(w, r, ErrServiceUnavailable)
 			return
 		}
-		if strings.Contains(err.Error(), "id not found") {
+		if errors.Is(err, store.ErrNotFound) {
 			render.Render(w, r, ErrNotFound)
 			return
 		}
@@ -0,0 +1,223 @@
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amoralesc/email-indexer/indexer/attachments"
+	"github.com/amoralesc/email-indexer/indexer/email"
+	"github.com/amoralesc/email-indexer/indexer/store"
+	"github.com/amoralesc/email-indexer/indexer/store/memory"
+)
+
+// attachmentContent is the content of the attachment of e1.
+const attachmentContent = "quarterly numbers"
+
+// newTestServer returns a server with the router of a memory store with three emails,
+// and an attachment store (in a temporary directory) with the attachment of e1.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	hash := sha256.Sum256([]byte(attachmentContent))
+	attachment := email.Attachment{
+		Filename:    "numbers.txt",
+		ContentType: "text/plain",
+		Size:        len(attachmentContent),
+		Hash:        hex.EncodeToString(hash[:]),
+	}
+	missingHash := sha256.Sum256([]byte("never saved"))
+	missing := email.Attachment{Filename: "missing.txt", ContentType: "text/plain", Hash: hex.EncodeToString(missingHash[:])}
+
+	emailStore := memory.NewMemoryStore()
+	emailStore.AddEmails([]store.EmailWithId{
+		{
+			Id: "e1", MessageId: "<1@x>", Date: time.Date(2001, time.January, 10, 0, 0, 0, 0, time.UTC),
+			From: "alice@enron.com", To: []string{"bob@enron.com"},
+			Subject: "Budget meeting", Body: "The budget for next quarter",
+			Attachments: []email.Attachment{attachment, missing}, IsStarred: true,
+		},
+		{
+			Id: "e2", MessageId: "<2@x>", Date: time.Date(2001, time.February, 10, 0, 0, 0, 0, time.UTC),
+			From: "bob@enron.com", To: []string{"alice@enron.com"},
+			Subject: "Lunch plans", Body: "Lunch at noon",
+		},
+		{
+			Id: "e3", MessageId: "<3@x>", Date: time.Date(2001, time.March, 10, 0, 0, 0, 0, time.UTC),
+			From: "carl@enron.com", To: []string{"alice@enron.com"},
+			Subject: "Quarterly report", Body: "Attached is the report",
+		},
+	})
+
+	attachmentStore := attachments.NewAttachmentStore(t.TempDir())
+	if err := attachmentStore.Save(attachment.Hash, []byte(attachmentContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(NewRouter(emailStore, attachmentStore))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// do sends a request to the server, and returns the status code and body of the response.
+func do(t *testing.T, server *httptest.Server, method string, path string, body string) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, respBody
+}
+
+// queryIds returns the total and the ids of the emails of a query response.
+func queryIds(t *testing.T, body []byte) (int, []string) {
+	t.Helper()
+	var resp store.QueryResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("error parsing response %s: %v", body, err)
+	}
+	ids := []string{}
+	for _, emailWithId := range resp.Emails {
+		ids = append(ids, emailWithId.Id)
+	}
+	return resp.Total, ids
+}
+
+func TestQueryHandlers(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		body      string
+		wantTotal int
+		wantIds   []string
+	}{
+		// start is 1 by default
+		{"list", "GET", "/api/emails/", "", 3, []string{"e2", "e1"}},
+		{"list from the start", "GET", "/api/emails/?start=0&sortBy=date", "", 3, []string{"e1", "e2", "e3"}},
+		{"list a page", "GET", "/api/emails/?start=1&size=1&sortBy=date", "", 3, []string{"e2"}},
+		{"list starred", "GET", "/api/emails/?start=0&starredOnly=true", "", 1, []string{"e1"}},
+		{"list sorted by from", "GET", "/api/emails/?start=0&sortBy=-from", "", 3, []string{"e3", "e2", "e1"}},
+		{"search", "POST", "/api/emails/search?start=0", `{"to": ["alice@enron.com"]}`, 2, []string{"e3", "e2"}},
+		{"search text", "POST", "/api/emails/search?start=0", `{"subjectIncludes": "budget report"}`, 2, []string{"e3", "e1"}},
+		{"query", "GET", "/api/emails/query?start=0&q=%2Bsubject:budget", "", 1, []string{"e1"}},
+		{"query with must not", "GET", "/api/emails/query?start=0&q=-from:bob@enron.com", "", 2, []string{"e3", "e1"}},
+	}
+
+	server := newTestServer(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statusCode, body := do(t, server, test.method, test.path, test.body)
+			if statusCode != http.StatusOK {
+				t.Fatalf("got status %v, want 200: %s", statusCode, body)
+			}
+			total, ids := queryIds(t, body)
+			if total != test.wantTotal || !reflect.DeepEqual(ids, test.wantIds) {
+				t.Errorf("got %v %v, want %v %v", total, ids, test.wantTotal, test.wantIds)
+			}
+		})
+	}
+}
+
+func TestStatusCodes(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"get", "GET", "/api/emails/e1", "", http.StatusOK},
+		{"get missing", "GET", "/api/emails/missing", "", http.StatusNotFound},
+		{"get by message id", "GET", "/api/emails/messageId/%3C2@x%3E", "", http.StatusOK},
+		{"get by missing message id", "GET", "/api/emails/messageId/%3Cmissing@x%3E", "", http.StatusNotFound},
+		{"update missing", "PUT", "/api/emails/missing", `{"subject": "new"}`, http.StatusNotFound},
+		{"update without body", "PUT", "/api/emails/e1", "", http.StatusBadRequest},
+		{"delete missing", "DELETE", "/api/emails/missing", "", http.StatusNotFound},
+		{"delete many without ids", "DELETE", "/api/emails/", "", http.StatusBadRequest},
+		{"invalid sort field", "GET", "/api/emails/?sortBy=subject", "", http.StatusBadRequest},
+		{"invalid start", "GET", "/api/emails/?start=first", "", http.StatusBadRequest},
+		{"search without body", "POST", "/api/emails/search", "", http.StatusBadRequest},
+		{"empty query string", "GET", "/api/emails/query", "", http.StatusBadRequest},
+		{"attachment", "GET", "/api/emails/e1/attachments/0", "", http.StatusOK},
+		{"attachment out of range", "GET", "/api/emails/e1/attachments/2", "", http.StatusNotFound},
+		{"attachment not saved", "GET", "/api/emails/e1/attachments/1", "", http.StatusNotFound},
+		{"attachment of missing email", "GET", "/api/emails/missing/attachments/0", "", http.StatusNotFound},
+		{"invalid attachment number", "GET", "/api/emails/e1/attachments/-1", "", http.StatusBadRequest},
+	}
+
+	server := newTestServer(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if statusCode, body := do(t, server, test.method, test.path, test.body); statusCode != test.want {
+				t.Errorf("got status %v, want %v: %s", statusCode, test.want, body)
+			}
+		})
+	}
+}
+
+func TestGetEmailAttachment(t *testing.T) {
+	server := newTestServer(t)
+	resp, err := server.Client().Get(server.URL + "/api/emails/e1/attachments/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if string(body) != attachmentContent {
+		t.Errorf("got content %q, want %q", body, attachmentContent)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "text/plain" {
+		t.Errorf("got content type %q, want text/plain", got)
+	}
+	if got := resp.Header.Get("Content-Disposition"); got != "attachment; filename=numbers.txt" {
+		t.Errorf("got content disposition %q", got)
+	}
+}
+
+func TestUpdateAndDelete(t *testing.T) {
+	server := newTestServer(t)
+
+	statusCode, body := do(t, server, "PUT", "/api/emails/e2", `{"subject": "Dinner plans", "from": "bob@enron.com"}`)
+	if statusCode != http.StatusOK {
+		t.Fatalf("update: got status %v: %s", statusCode, body)
+	}
+	_, body = do(t, server, "GET", "/api/emails/e2", "")
+	var emailWithId store.EmailWithId
+	if err := json.Unmarshal(body, &emailWithId); err != nil {
+		t.Fatal(err)
+	}
+	if emailWithId.Subject != "Dinner plans" {
+		t.Errorf("got subject %q after update, want Dinner plans", emailWithId.Subject)
+	}
+
+	if statusCode, body := do(t, server, "DELETE", "/api/emails/e2", ""); statusCode != http.StatusOK {
+		t.Fatalf("delete: got status %v: %s", statusCode, body)
+	}
+	if statusCode, _ := do(t, server, "GET", "/api/emails/e2", ""); statusCode != http.StatusNotFound {
+		t.Errorf("got status %v after delete, want 404", statusCode)
+	}
+	if statusCode, body := do(t, server, "DELETE", "/api/emails/?ids=e1,e3,missing", ""); statusCode != http.StatusOK {
+		t.Fatalf("delete many: got status %v: %s", statusCode, body)
+	}
+	_, body = do(t, server, "GET", "/api/emails/?start=0", "")
+	if total, ids := queryIds(t, body); total != 0 {
+		t.Errorf("got %v %v after deleting every email, want none", total, ids)
+	}
+}
@@ -0,0 +1,163 @@
+package routines
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/amoralesc/email-indexer/indexer/mbox"
+)
+
+// isArchive returns true if the file located at path is an archive the indexer can read.
+func isArchive(path string) bool {
+	name := strings.ToLower(path)
+	for _, extension := range []string{".tar", ".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// readArchive reads the archive located at path and sends the messages of its entries
+// to the sources channel. The entries are streamed from the archive, they are never
+// extracted to disk. relPath is the path of the archive relative to the emails directory.
+// The entries skipped by the filter aren't read, and are counted in progress.
+func readArchive(ctx context.Context, path string, relPath string, maildir bool, filter *FileFilter, progress *Progress, sources chan<- *emailSource) error {
+	name := strings.ToLower(path)
+	if strings.HasSuffix(name, ".zip") {
+		return readZipArchive(ctx, path, relPath, maildir, filter, progress, sources)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		r = gzipReader
+	}
+
+	return readTarArchive(ctx, r, relPath, maildir, filter, progress, sources)
+}
+
+// readTarArchive reads the entries of a tar archive from r and sends their messages to the sources channel.
+func readTarArchive(ctx context.Context, r io.Reader, archive string, maildir bool, filter *FileFilter, progress *Progress, sources chan<- *emailSource) error {
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !header.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		if isEntryFiltered(header.Name, header.Size, filter, progress) {
+			continue
+		}
+
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		if err := sendArchiveEntry(ctx, archive, header.Name, header.ModTime, content, maildir, sources); err != nil {
+			return err
+		}
+	}
+}
+
+// readZipArchive reads the entries of the zip archive located at path and sends their messages to the sources channel.
+func readZipArchive(ctx context.Context, path string, archive string, maildir bool, filter *FileFilter, progress *Progress, sources chan<- *emailSource) error {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		if isEntryFiltered(file.Name, int64(file.UncompressedSize64), filter, progress) {
+			continue
+		}
+
+		entry, err := file.Open()
+		if err != nil {
+			log.Printf("WARN: failed to read %v:%v: %v", archive, file.Name, err)
+			continue
+		}
+		content, err := io.ReadAll(entry)
+		entry.Close()
+		if err != nil {
+			log.Printf("WARN: failed to read %v:%v: %v", archive, file.Name, err)
+			continue
+		}
+		if err := sendArchiveEntry(ctx, archive, file.Name, file.Modified, content, maildir, sources); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isEntryFiltered returns true if the filter skips the archive entry with the given
+// name and size, counting it in progress.
+func isEntryFiltered(name string, size int64, filter *FileFilter, progress *Progress) bool {
+	reason := filter.skipFile(cleanEntryName(name), size)
+	if reason == "" {
+		return false
+	}
+	progress.addSkippedFile(reason)
+	return true
+}
+
+// cleanEntryName returns the name of an archive entry as a clean relative path.
+func cleanEntryName(name string) string {
+	return path.Clean(strings.TrimPrefix(name, "./"))
+}
+
+// sendArchiveEntry sends the messages of an archive entry to the sources channel,
+// following the same rules as the files of the emails directory. It only fails if ctx is done.
+func sendArchiveEntry(ctx context.Context, archive string, name string, modTime time.Time, content []byte, maildir bool, sources chan<- *emailSource) error {
+	name = cleanEntryName(name)
+	entrySource := &emailSource{archive: archive, path: name, modTime: modTime, content: content}
+
+	if maildir {
+		if isMaildirMessage(name) {
+			entrySource.maildir = true
+			return send(ctx, sources, entrySource)
+		}
+		return nil
+	}
+
+	if mbox.IsMboxContent(content) {
+		entrySource.content = nil
+		if err := readMbox(ctx, bytes.NewReader(content), entrySource, sources); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			log.Printf("WARN: failed to read mbox %v: %v", entrySource, err)
+		}
+		return nil
+	}
+
+	return send(ctx, sources, entrySource)
+}
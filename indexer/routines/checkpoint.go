@@ -0,0 +1,159 @@
+package routines
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// checkpointRecord is a line of the checkpoint file. The first line holds the emails
+// directory of the run, and every other line a message acknowledged by zinc.
+type checkpointRecord struct {
+	Dir    string `json:"dir,omitempty"`
+	Source string `json:"source,omitempty"` // see checkpointKey
+	Id     string `json:"id,omitempty"`     // id of the email uploaded from the source
+}
+
+// Checkpoint records the messages of an indexing run whose batches were acknowledged
+// by zinc, so a run that didn't finish (e.g. the indexer crashed or zinc restarted)
+// continues from where it stopped instead of starting over. The records are appended
+// to a file as the batches are uploaded, and the file is removed when the run finishes.
+type Checkpoint struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+	done map[string]string // source key -> email id
+}
+
+// checkpointKey returns the key of a message in the checkpoint: the path of its source
+// file (in its archive, if any) and its offset in the file.
+func checkpointKey(archive string, path string, offset int64) string {
+	return fmt.Sprintf("%v:%v@%d", archive, filepath.ToSlash(path), offset)
+}
+
+// HasCheckpoint returns true if there's a checkpoint at path, that is, if the last
+// indexing run didn't finish.
+func HasCheckpoint(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// LoadCheckpoint loads the checkpoint saved at path and opens it to record the messages
+// of the run over the emails directory dir. A checkpoint of another emails directory is discarded.
+func LoadCheckpoint(path string, dir string) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{path: path, done: map[string]string{}}
+
+	file, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		// a crash may leave the last line incomplete, the records before it are kept
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for i := 0; scanner.Scan(); i++ {
+			var record checkpointRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				break
+			}
+			if i == 0 && record.Dir != dir {
+				break
+			}
+			if i > 0 {
+				checkpoint.done[record.Source] = record.Id
+			}
+		}
+		file.Close()
+	}
+
+	if err := checkpoint.rewrite(dir); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// rewrite writes the records loaded to a new checkpoint file and opens it to append to it.
+func (checkpoint *Checkpoint) rewrite(dir string) error {
+	if err := os.MkdirAll(filepath.Dir(checkpoint.path), 0755); err != nil {
+		return err
+	}
+	tmp := checkpoint.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	encoder.Encode(checkpointRecord{Dir: dir})
+	for source, id := range checkpoint.done {
+		encoder.Encode(checkpointRecord{Source: source, Id: id})
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, checkpoint.path); err != nil {
+		return err
+	}
+
+	checkpoint.file, err = os.OpenFile(checkpoint.path, os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+// Len returns the number of messages acknowledged by the previous runs.
+func (checkpoint *Checkpoint) Len() int {
+	checkpoint.mu.Lock()
+	defer checkpoint.mu.Unlock()
+	return len(checkpoint.done)
+}
+
+// isDone returns the id of the email uploaded from the message with the given key,
+// and true if it was acknowledged by zinc.
+func (checkpoint *Checkpoint) isDone(key string) (string, bool) {
+	checkpoint.mu.Lock()
+	defer checkpoint.mu.Unlock()
+	id, ok := checkpoint.done[key]
+	return id, ok
+}
+
+// add records the messages of a batch acknowledged by zinc. The file is synced
+// before returning, so the batch is never uploaded again by a resumed run.
+func (checkpoint *Checkpoint) add(keys []string, ids []string) error {
+	checkpoint.mu.Lock()
+	defer checkpoint.mu.Unlock()
+
+	writer := bufio.NewWriter(checkpoint.file)
+	encoder := json.NewEncoder(writer)
+	for i := range keys {
+		if err := encoder.Encode(checkpointRecord{Source: keys[i], Id: ids[i]}); err != nil {
+			return err
+		}
+		checkpoint.done[keys[i]] = ids[i]
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return checkpoint.file.Sync()
+}
+
+// Remove closes and removes the checkpoint file, once the run finished.
+func (checkpoint *Checkpoint) Remove() error {
+	checkpoint.mu.Lock()
+	defer checkpoint.mu.Unlock()
+	checkpoint.file.Close()
+	return os.Remove(checkpoint.path)
+}
+
+// RemoveCheckpoint removes the checkpoint saved at path, if there's one.
+func RemoveCheckpoint(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package routines
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/amoralesc/email-indexer/indexer/store"
+)
+
+// DeadLetter is an email that zinc didn't accept, with the error it responded with.
+type DeadLetter struct {
+	Error    string            `json:"error"`
+	FailedAt time.Time         `json:"failedAt"`
+	Email    store.EmailWithId `json:"email"`
+}
+
+// DeadLetterQueue saves the emails that failed to upload to a directory, as a
+// JSON file per email named after its id, so the rest of the run continues.
+type DeadLetterQueue struct {
+	Dir string
+}
+
+// NewDeadLetterQueue returns a dead-letter queue that saves the emails to dir.
+func NewDeadLetterQueue(dir string) *DeadLetterQueue {
+	return &DeadLetterQueue{Dir: dir}
+}
+
+// Add saves an email that failed to upload with err to the queue.
+// An email that failed before is replaced, with the new error.
+func (queue *DeadLetterQueue) Add(record store.EmailWithId, err error) error {
+	content, jsonErr := json.MarshalIndent(DeadLetter{Error: err.Error(), FailedAt: time.Now().UTC(), Email: record}, "", "  ")
+	if jsonErr != nil {
+		return jsonErr
+	}
+	if err := os.MkdirAll(queue.Dir, 0755); err != nil {
+		return err
+	}
+	// write to a temporary file first so a crash never leaves a partial dead letter
+	path := filepath.Join(queue.Dir, record.Id+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
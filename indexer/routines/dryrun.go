@@ -0,0 +1,234 @@
+package routines
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/amoralesc/email-indexer/indexer/email"
+)
+
+// dryRunTopCount is the number of failure reasons and senders in a dry run report.
+const dryRunTopCount = 20
+
+// DryRun gathers statistics about the emails parsed by a dry run, which parses
+// the emails directory without uploading anything (see DryRunEmails).
+type DryRun struct {
+	mu        sync.Mutex
+	messages  int
+	parsed    int
+	failed    int
+	headers   map[string]int // header -> emails that have it
+	dates     map[string]int // date source -> emails
+	firstDate time.Time
+	lastDate  time.Time
+	classes   map[string]int // error class -> messages
+	reasons   map[string]int // error -> messages
+	senders   map[string]int // sender address -> emails
+}
+
+// DryRunCount is the number of emails (or messages) with a value.
+type DryRunCount struct {
+	Value   string  `json:"value"`
+	Count   int     `json:"count"`
+	Percent float64 `json:"percent"` // of the emails parsed (or messages failed, for failure reasons)
+}
+
+// DryRunReport is the report of a dry run. The date range only has the dates
+// found in the headers, not the ones that fell back to the modification time.
+type DryRunReport struct {
+	Messages        int            `json:"messages"`
+	Parsed          int            `json:"parsed"`
+	Failed          int            `json:"failed"`
+	HeaderCoverage  []*DryRunCount `json:"headerCoverage"` // sorted by count
+	DateSources     map[string]int `json:"dateSources"`    // see email.DateSource*
+	FirstDate       *time.Time     `json:"firstDate"`
+	LastDate        *time.Time     `json:"lastDate"`
+	FailuresByClass map[string]int `json:"failuresByClass"` // see email.ErrorClass
+	FailureReasons  []*DryRunCount `json:"failureReasons"`  // the most common ones
+	TopSenders      []*DryRunCount `json:"topSenders"`
+}
+
+// NewDryRun returns an empty dry run.
+func NewDryRun() *DryRun {
+	return &DryRun{
+		headers: map[string]int{},
+		dates:   map[string]int{},
+		classes: map[string]int{},
+		reasons: map[string]int{},
+		senders: map[string]int{},
+	}
+}
+
+// add counts a parsed email.
+func (dryRun *DryRun) add(emailObj *email.Email) {
+	dryRun.mu.Lock()
+	defer dryRun.mu.Unlock()
+
+	dryRun.messages++
+	dryRun.parsed++
+	for header := range emailObj.Headers {
+		dryRun.headers[header]++
+	}
+	dryRun.dates[emailObj.DateSource]++
+	if emailObj.DateSource != email.DateSourceModTime && emailObj.DateSource != email.DateSourceNone {
+		if dryRun.firstDate.IsZero() || emailObj.Date.Before(dryRun.firstDate) {
+			dryRun.firstDate = emailObj.Date
+		}
+		if dryRun.lastDate.IsZero() || emailObj.Date.After(dryRun.lastDate) {
+			dryRun.lastDate = emailObj.Date
+		}
+	}
+	if emailObj.Sender.Address != "" {
+		dryRun.senders[emailObj.Sender.Address]++
+	}
+}
+
+// addFailure counts a message that failed to parse with err.
+func (dryRun *DryRun) addFailure(err error) {
+	dryRun.mu.Lock()
+	defer dryRun.mu.Unlock()
+
+	dryRun.messages++
+	dryRun.failed++
+	dryRun.classes[email.ErrorClass(err)]++
+	dryRun.reasons[failureReason(err)]++
+}
+
+// failureReason returns the reason of a parse error, without the path of the file
+// it's about, so the errors of different files with the same reason are counted together.
+func failureReason(err error) string {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return email.ErrorClass(err) + ": " + pathErr.Op + ": " + pathErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// collectEmails is a routine that counts the emails from a channel of emails, and
+// writes them to output (if not nil).
+func (dryRun *DryRun) collectEmails(emails <-chan *email.Email, output *EmailWriter) {
+	for emailObj := range emails {
+		dryRun.add(emailObj)
+		if output != nil {
+			output.write(emailObj)
+		}
+	}
+}
+
+// Report returns the report of the emails counted so far.
+func (dryRun *DryRun) Report() *DryRunReport {
+	dryRun.mu.Lock()
+	defer dryRun.mu.Unlock()
+
+	report := &DryRunReport{
+		Messages:        dryRun.messages,
+		Parsed:          dryRun.parsed,
+		Failed:          dryRun.failed,
+		HeaderCoverage:  topCounts(dryRun.headers, dryRun.parsed, 0),
+		DateSources:     copyCounts(dryRun.dates),
+		FailuresByClass: copyCounts(dryRun.classes),
+		FailureReasons:  topCounts(dryRun.reasons, dryRun.failed, dryRunTopCount),
+		TopSenders:      topCounts(dryRun.senders, dryRun.parsed, dryRunTopCount),
+	}
+	if !dryRun.firstDate.IsZero() {
+		first, last := dryRun.firstDate, dryRun.lastDate
+		report.FirstDate, report.LastDate = &first, &last
+	}
+	return report
+}
+
+// topCounts returns the n values with the highest counts (all of them if n is 0),
+// sorted by count and value, with their percent of total.
+func topCounts(counts map[string]int, total int, n int) []*DryRunCount {
+	top := make([]*DryRunCount, 0, len(counts))
+	for value, count := range counts {
+		percent := 0.0
+		if total > 0 {
+			percent = 100 * float64(count) / float64(total)
+		}
+		top = append(top, &DryRunCount{Value: value, Count: count, Percent: percent})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Value < top[j].Value
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// copyCounts returns a copy of a map of counts.
+func copyCounts(counts map[string]int) map[string]int {
+	copied := make(map[string]int, len(counts))
+	for value, count := range counts {
+		copied[value] = count
+	}
+	return copied
+}
+
+// Save writes the report of the dry run to path, as JSON.
+func (report *DryRunReport) Save(path string) error {
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+// log logs the report of the dry run.
+func (report *DryRunReport) log() {
+	log.Printf("INFO: dry run: %d messages, %d parsed, %d failed", report.Messages, report.Parsed, report.Failed)
+	if report.FirstDate != nil {
+		log.Printf("INFO: dates from %v to %v", report.FirstDate.Format(time.RFC3339), report.LastDate.Format(time.RFC3339))
+	}
+	logCounts("date sources", topCounts(report.DateSources, report.Parsed, 0))
+	logCounts("header coverage", report.HeaderCoverage)
+	logCounts("top senders", report.TopSenders)
+	logCounts("failures by class", topCounts(report.FailuresByClass, report.Failed, 0))
+	logCounts("top failure reasons", report.FailureReasons)
+}
+
+// logCounts logs a list of counts under a title, if it isn't empty.
+func logCounts(title string, counts []*DryRunCount) {
+	if len(counts) == 0 {
+		return
+	}
+	log.Printf("INFO: %v:", title)
+	for _, count := range counts {
+		log.Printf("INFO:   %v: %d (%.1f%%)", count.Value, count.Count, count.Percent)
+	}
+}
+
+// DryRunEmails parses the emails directory like ParseAndUploadEmails, but the emails
+// are counted in the dry run of the config (and written to its export, if any, which
+// is closed afterwards) instead of uploaded. Nothing is saved to the attachment store, quarantine or manifest.
+// It returns the report of the run, which is also logged.
+func DryRunEmails(ctx context.Context, config *IndexerConfig) *DryRunReport {
+	runPipeline(ctx, config, func(sources chan<- *emailSource) error {
+		go config.progress.countFiles(config.Dir, config.Maildir, config.Filter)
+		return walkEmailsDir(ctx, config.Dir, config.Maildir, config.Filter, nil, config.progress, sources)
+	})
+	if config.Export != nil {
+		if err := config.Export.Close(); err != nil {
+			log.Printf("ERROR: failed to write the parsed emails: %v", err)
+		}
+	}
+
+	report := config.DryRun.Report()
+	report.log()
+	return report
+}
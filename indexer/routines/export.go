@@ -0,0 +1,191 @@
+package routines
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/amoralesc/email-indexer/indexer/email"
+)
+
+// exportMaxLineSize is the size of the longest line (email) an import can read.
+const exportMaxLineSize = 64 * 1024 * 1024
+
+// EmailWriter writes parsed emails to an NDJSON file, one email per line. If the
+// path of the file ends with .gz, it's compressed with gzip. It's safe for concurrent use.
+type EmailWriter struct {
+	mu      sync.Mutex
+	file    *os.File
+	gzip    *gzip.Writer // nil if the file isn't compressed
+	output  *bufio.Writer
+	encoder *json.Encoder
+	written int
+	err     error // the first error writing the file
+}
+
+// CreateEmailWriter creates the NDJSON file located at path, truncating it if it exists.
+func CreateEmailWriter(path string) (*EmailWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := &EmailWriter{file: file}
+	var out io.Writer = file
+	if strings.HasSuffix(path, ".gz") {
+		writer.gzip = gzip.NewWriter(file)
+		out = writer.gzip
+	}
+	writer.output = bufio.NewWriter(out)
+	writer.encoder = json.NewEncoder(writer.output)
+	writer.encoder.SetEscapeHTML(false)
+	return writer, nil
+}
+
+// write writes an email to the file. Once a write fails, the next ones are skipped
+// and Close returns the error.
+func (writer *EmailWriter) write(emailObj *email.Email) {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if writer.err != nil {
+		return
+	}
+	writer.err = writer.encoder.Encode(emailObj)
+	if writer.err == nil {
+		writer.written++
+	}
+}
+
+// writeEmails is a routine that writes the emails from a channel of emails to the file.
+func (writer *EmailWriter) writeEmails(emails <-chan *email.Email, progress *Progress) {
+	for emailObj := range emails {
+		writer.write(emailObj)
+		progress.addUploaded(1)
+	}
+}
+
+// Written returns the number of emails written to the file.
+func (writer *EmailWriter) Written() int {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	return writer.written
+}
+
+// Close flushes the emails written and closes the file. It returns the first error
+// writing the file, if there was one.
+func (writer *EmailWriter) Close() error {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	err := writer.err
+	if flushErr := writer.output.Flush(); err == nil {
+		err = flushErr
+	}
+	if writer.gzip != nil {
+		if closeErr := writer.gzip.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := writer.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ExportEmails parses the emails directory like ParseAndUploadEmails, but the emails
+// are written to the export of the config instead of uploaded, so they can be imported
+// later without parsing them again (see ImportEmails). The attachments are saved to the
+// attachment store, since their content isn't exported. The emails written are counted
+// as uploaded in the progress of the run. The export is closed afterwards.
+func ExportEmails(ctx context.Context, config *IndexerConfig) error {
+	runPipeline(ctx, config, func(sources chan<- *emailSource) error {
+		go config.progress.countFiles(config.Dir, config.Maildir, config.Filter)
+		return walkEmailsDir(ctx, config.Dir, config.Maildir, config.Filter, nil, config.progress, sources)
+	})
+	return config.Export.Close()
+}
+
+// ImportEmails uploads the emails of an NDJSON file written by ExportEmails (compressed
+// or not) to zinc, with the uploader routines of an indexing run. Since the emails keep
+// their ids, importing them again updates the same documents. The lines that can't be
+// decoded are skipped. The import stops when ctx is done.
+func ImportEmails(ctx context.Context, config *IndexerConfig, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return runUploaders(ctx, config, func(emails chan<- *email.Email) error {
+		if info, err := file.Stat(); err == nil {
+			config.progress.setTotals(1, info.Size())
+		}
+		reader, err := decompressedReader(&progressReader{file, config.progress})
+		if err != nil {
+			return err
+		}
+
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 0, 64*1024), exportMaxLineSize)
+		line := 0
+		for scanner.Scan() {
+			line++
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			var emailObj email.Email
+			if err := json.Unmarshal(scanner.Bytes(), &emailObj); err != nil {
+				log.Printf("WARN: failed to decode line %d of %v: %v", line, path, err)
+				config.progress.addMessage(false, true, false)
+				continue
+			}
+			config.progress.addMessage(true, false, false)
+			select {
+			case emails <- &emailObj:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		config.progress.addFile(0)
+		return nil
+	})
+}
+
+// progressReader counts the bytes read from a file in the progress of a run.
+type progressReader struct {
+	r        io.Reader
+	progress *Progress
+}
+
+func (reader *progressReader) Read(p []byte) (int, error) {
+	n, err := reader.r.Read(p)
+	reader.progress.addBytes(int64(n))
+	return n, err
+}
+
+// decompressedReader returns a reader of the content of r, decompressing it if it's gzipped.
+func decompressedReader(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(buffered)
+	}
+	return buffered, nil
+}
@@ -0,0 +1,97 @@
+package routines
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// The reasons a file is skipped by a FileFilter.
+const (
+	skipReasonExcluded = "excluded"  // it doesn't match the include patterns, or matches an exclude one
+	skipReasonTooLarge = "too-large" // it's larger than the maximum size
+	skipReasonFolder   = "folder"    // it's in a denied folder
+)
+
+// FileFilter selects the files of the emails directory (and the entries of its archives)
+// that are indexed. A pattern with a / is matched against the path of a file relative
+// to the emails directory (or archive), and one without against its name, with the
+// syntax of path.Match. Folders are matched by name, at any depth and ignoring case.
+// Its methods can be called on a nil FileFilter, which doesn't skip anything.
+type FileFilter struct {
+	Include     []string // if not empty, only the files that match one of them are indexed (archives aren't checked, their entries are)
+	Exclude     []string // the files that match one of them aren't indexed
+	MaxSize     int64    // size of the largest file indexed in bytes (archives aren't checked, their entries are), no limit if 0
+	DenyFolders []string // the files in the folders that match one of them aren't indexed
+}
+
+// NewFileFilter returns a filter with the given patterns, which are validated.
+func NewFileFilter(include []string, exclude []string, maxSize int64, denyFolders []string) (*FileFilter, error) {
+	filter := &FileFilter{Include: include, Exclude: exclude, MaxSize: maxSize}
+	for _, pattern := range denyFolders {
+		filter.DenyFolders = append(filter.DenyFolders, strings.ToLower(pattern))
+	}
+	for _, patterns := range [][]string{filter.Include, filter.Exclude, filter.DenyFolders} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return filter, nil
+}
+
+// skipFolder returns true if the files in the folder with the given name aren't indexed.
+func (filter *FileFilter) skipFolder(name string) bool {
+	if filter == nil {
+		return false
+	}
+	return matchesAny(filter.DenyFolders, strings.ToLower(name), "")
+}
+
+// skipFile returns the reason the file located at relPath (relative to the emails directory
+// or archive, with / separators) with the given size isn't indexed, or "" if it's indexed.
+// A negative size isn't checked.
+func (filter *FileFilter) skipFile(relPath string, size int64) string {
+	if filter == nil {
+		return ""
+	}
+	// the folders are skipped by the walk, but not the ones of archive entries or watch events
+	folders := strings.Split(path.Dir(relPath), "/")
+	for _, folder := range folders {
+		if folder != "." && filter.skipFolder(folder) {
+			return skipReasonFolder
+		}
+	}
+
+	name := path.Base(relPath)
+	if matchesAny(filter.Exclude, name, relPath) {
+		return skipReasonExcluded
+	}
+	// the include patterns and maximum size are checked against the entries of archives
+	if isArchive(relPath) {
+		return ""
+	}
+	if len(filter.Include) > 0 && !matchesAny(filter.Include, name, relPath) {
+		return skipReasonExcluded
+	}
+	if filter.MaxSize > 0 && size > filter.MaxSize {
+		return skipReasonTooLarge
+	}
+	return ""
+}
+
+// matchesAny returns true if the name or path of a file matches one of the patterns.
+// The patterns with a / are matched against relPath, and the others against name.
+func matchesAny(patterns []string, name string, relPath string) bool {
+	for _, pattern := range patterns {
+		target := name
+		if strings.Contains(pattern, "/") {
+			target = relPath
+		}
+		if matched, _ := path.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
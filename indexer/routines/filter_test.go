@@ -0,0 +1,91 @@
+package routines
+
+import "testing"
+
+func TestFileFilter(t *testing.T) {
+	filter, err := NewFileFilter(
+		[]string{"*.eml", "lay-k/sent/*"},
+		[]string{"*draft*", "lay-k/sent/*.bak"},
+		100,
+		[]string{"Trash", "deleted_*"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		relPath string
+		size    int64
+		want    string
+	}{
+		{"lay-k/inbox/1.eml", 10, ""},
+		{"lay-k/inbox/1.txt", 10, skipReasonExcluded},
+		{"lay-k/sent/1.txt", 10, ""},                     // included by its path
+		{"lay-k/sent/old/1.txt", 10, skipReasonExcluded}, // path.Match doesn't cross a /
+		{"lay-k/sent/1.bak", 10, skipReasonExcluded},     // excluded by its path
+		{"lay-k/inbox/my-draft.eml", 10, skipReasonExcluded},
+		{"lay-k/inbox/1.eml", 101, skipReasonTooLarge},
+		{"lay-k/inbox/1.eml", -1, ""},
+		{"lay-k/trash/1.eml", 10, skipReasonFolder},
+		{"lay-k/TRASH/1.eml", 10, skipReasonFolder},
+		{"trash/1.eml", 10, skipReasonFolder},
+		{"lay-k/Deleted_Items/old/1.eml", 10, skipReasonFolder},
+		{"lay-k/trashed/1.eml", 10, ""},
+		{"trash.eml", 10, ""}, // only folders are denied
+		{"lay-k/inbox.zip", 1000, ""},
+		{"lay-k/draft.zip", 10, skipReasonExcluded},
+		{"lay-k/trash/inbox.zip", 10, skipReasonFolder},
+	}
+
+	for _, test := range tests {
+		if got := filter.skipFile(test.relPath, test.size); got != test.want {
+			t.Errorf("%q (%d bytes): got %q, want %q", test.relPath, test.size, got, test.want)
+		}
+	}
+}
+
+func TestFileFilterSkipFolder(t *testing.T) {
+	filter, err := NewFileFilter(nil, nil, 0, []string{"Trash", "deleted_*", "Archive"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"trash", true},
+		{"Trash", true},
+		{"TRASH", true},
+		{"Deleted_Items", true},
+		{"deleted", false},
+		{"archive", true},
+		{"ARCHIVE", true},
+		{"inbox", false},
+	}
+
+	for _, test := range tests {
+		if got := filter.skipFolder(test.name); got != test.want {
+			t.Errorf("%q: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNilFileFilter(t *testing.T) {
+	var filter *FileFilter
+	if filter.skipFolder("trash") || filter.skipFile("trash/1.eml", 1<<40) != "" {
+		t.Error("a nil filter skipped a file")
+	}
+}
+
+func TestNewFileFilterInvalidPattern(t *testing.T) {
+	for _, patterns := range [][][]string{
+		{{"[a-"}, nil, nil},
+		{nil, {"inbox/[a-"}, nil},
+		{nil, nil, {"[a-"}},
+	} {
+		if _, err := NewFileFilter(patterns[0], patterns[1], 0, patterns[2]); err == nil {
+			t.Errorf("%q: invalid pattern accepted", patterns)
+		}
+	}
+}
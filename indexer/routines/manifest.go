@@ -0,0 +1,245 @@
+package routines
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestEntry is a file of the emails directory that was indexed.
+type manifestEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Hash    string    `json:"hash"` // sha256 of the content (hex encoded)
+	Ids     []string  `json:"ids"`  // ids of the emails indexed from the file
+}
+
+// Manifest is the list of files indexed by the previous runs, with the emails
+// indexed from them. It's used to index only the new or changed files of the
+// emails directory and to delete the emails of the files that were removed.
+type Manifest struct {
+	path     string
+	mu       sync.Mutex
+	previous map[string]*manifestEntry // files indexed by the previous runs
+	current  map[string]*manifestEntry // files found by this run
+}
+
+// LoadManifest loads the manifest saved at path. If there isn't one, the manifest is empty.
+func LoadManifest(path string) (*Manifest, error) {
+	manifest := &Manifest{
+		path:     path,
+		previous: map[string]*manifestEntry{},
+		current:  map[string]*manifestEntry{},
+	}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &manifest.previous); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Save saves the files found by this run to the manifest path.
+func (manifest *Manifest) Save() error {
+	manifest.mu.Lock()
+	defer manifest.mu.Unlock()
+
+	content, err := json.Marshal(manifest.current)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(manifest.path), 0755); err != nil {
+		return err
+	}
+	// write to a temporary file first so a crash never leaves a partial manifest
+	tmp := manifest.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, manifest.path)
+}
+
+// checkFile adds the file located at path to the manifest under key, and returns
+// true if it's new or changed since the previous runs. A file whose size or
+// modification time changed is only changed if its content hash did too.
+func (manifest *Manifest) checkFile(path string, key string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return true, err
+	}
+
+	manifest.mu.Lock()
+	previous := manifest.previous[key]
+	manifest.mu.Unlock()
+	if previous != nil && previous.Size == info.Size() && previous.ModTime.Equal(info.ModTime()) {
+		manifest.setEntry(key, previous)
+		return false, nil
+	}
+
+	hash, err := hashFile(path)
+	if err != nil {
+		return true, err
+	}
+	if previous != nil && previous.Hash == hash {
+		manifest.setEntry(key, &manifestEntry{Size: info.Size(), ModTime: info.ModTime(), Hash: hash, Ids: previous.Ids})
+		return false, nil
+	}
+
+	manifest.setEntry(key, &manifestEntry{Size: info.Size(), ModTime: info.ModTime(), Hash: hash})
+	return true, nil
+}
+
+// setEntry sets the entry of a file found by this run.
+func (manifest *Manifest) setEntry(key string, entry *manifestEntry) {
+	manifest.mu.Lock()
+	defer manifest.mu.Unlock()
+	manifest.current[key] = entry
+}
+
+// addIds adds the ids of the emails indexed from the file with the given key.
+func (manifest *Manifest) addIds(key string, ids ...string) {
+	manifest.mu.Lock()
+	defer manifest.mu.Unlock()
+	if entry, ok := manifest.current[key]; ok {
+		entry.Ids = append(entry.Ids, ids...)
+	}
+}
+
+// staleIds returns the ids of the emails indexed by the previous runs that weren't
+// indexed by this run, because their files were removed or changed.
+func (manifest *Manifest) staleIds() []string {
+	manifest.mu.Lock()
+	defer manifest.mu.Unlock()
+
+	currentIds := map[string]bool{}
+	for _, entry := range manifest.current {
+		for _, id := range entry.Ids {
+			currentIds[id] = true
+		}
+	}
+
+	var stale []string
+	for _, entry := range manifest.previous {
+		for _, id := range entry.Ids {
+			if !currentIds[id] {
+				stale = append(stale, id)
+			}
+		}
+	}
+	return stale
+}
+
+// hashFile returns the sha256 hash (hex encoded) of the file located at path.
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// reset clears the files found by this run, to walk the emails directory again.
+func (manifest *Manifest) reset() {
+	manifest.mu.Lock()
+	defer manifest.mu.Unlock()
+	manifest.current = map[string]*manifestEntry{}
+}
+
+// commitAll makes the files found by this run the files indexed by the previous runs,
+// so the changes found afterwards (see commit) are compared against them.
+func (manifest *Manifest) commitAll() {
+	manifest.mu.Lock()
+	defer manifest.mu.Unlock()
+	manifest.previous = make(map[string]*manifestEntry, len(manifest.current))
+	for key, entry := range manifest.current {
+		manifest.previous[key] = entry
+	}
+}
+
+// keepAll makes the files indexed by the previous runs the files found by this run, so the
+// emails indexed without walking the emails directory (see RetryQuarantine) are added to
+// them, or a walk that didn't finish (see resyncEmails) is discarded.
+func (manifest *Manifest) keepAll() {
+	manifest.mu.Lock()
+	defer manifest.mu.Unlock()
+	manifest.current = make(map[string]*manifestEntry, len(manifest.previous))
+	for key, entry := range manifest.previous {
+		kept := *entry
+		kept.Ids = append([]string(nil), entry.Ids...)
+		manifest.current[key] = &kept
+	}
+}
+
+// removeFiles removes the file with the given key from the files found by this run,
+// along with the files under it if it's a directory. It returns the keys removed.
+func (manifest *Manifest) removeFiles(key string) []string {
+	manifest.mu.Lock()
+	defer manifest.mu.Unlock()
+
+	removed := map[string]bool{}
+	for _, entries := range []map[string]*manifestEntry{manifest.previous, manifest.current} {
+		for fileKey := range entries {
+			if fileKey == key || strings.HasPrefix(fileKey, key+"/") {
+				removed[fileKey] = true
+			}
+		}
+	}
+	keys := make([]string, 0, len(removed))
+	for fileKey := range removed {
+		delete(manifest.current, fileKey)
+		keys = append(keys, fileKey)
+	}
+	return keys
+}
+
+// commit makes the files with the given keys found by this run the files indexed by the
+// previous runs, like commitAll does for every file. It returns the ids of the emails
+// indexed from them before that weren't indexed again, because they were removed or changed.
+func (manifest *Manifest) commit(keys []string) []string {
+	manifest.mu.Lock()
+	defer manifest.mu.Unlock()
+
+	currentIds := map[string]bool{}
+	for _, key := range keys {
+		if entry, ok := manifest.current[key]; ok {
+			for _, id := range entry.Ids {
+				currentIds[id] = true
+			}
+		}
+	}
+
+	var stale []string
+	for _, key := range keys {
+		if entry, ok := manifest.previous[key]; ok {
+			for _, id := range entry.Ids {
+				if !currentIds[id] {
+					stale = append(stale, id)
+				}
+			}
+		}
+		if entry, ok := manifest.current[key]; ok {
+			manifest.previous[key] = entry
+		} else {
+			delete(manifest.previous, key)
+		}
+	}
+	return stale
+}
@@ -0,0 +1,345 @@
+package routines
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// The states of an indexing run.
+const (
+	RunStateRunning  = "running"
+	RunStateFinished = "finished"
+	RunStateCanceled = "canceled"
+)
+
+// Progress counts the work done by an indexing run. The files of the emails directory
+// are counted in the background when the run starts, to estimate the time left.
+// Its methods are safe for concurrent use, and do nothing on a nil Progress.
+type Progress struct {
+	started    time.Time
+	finished   atomic.Pointer[time.Time]
+	state      atomic.Value // string
+	filesTotal atomic.Int64 // -1 until counted
+	bytesTotal atomic.Int64 // -1 until counted
+
+	files        atomic.Int64 // files walked, whose messages were all sent to the parsers
+	bytes        atomic.Int64 // size of the files walked
+	messages     atomic.Int64 // messages received by the parsers
+	parsed       atomic.Int64
+	failed       atomic.Int64 // messages that failed to parse
+	skipped      atomic.Int64 // messages uploaded by a previous run (see Checkpoint)
+	uploaded     atomic.Int64
+	deadLettered atomic.Int64 // emails that failed to upload (see DeadLetterQueue)
+	pending      atomic.Int64 // emails that failed to upload with a temporary error, left for the next run
+
+	// files (and archive entries) skipped by the filter of the run (see FileFilter)
+	filesExcluded        atomic.Int64
+	filesTooLarge        atomic.Int64
+	filesInDeniedFolders atomic.Int64 // in archives or watch events, the denied folders of the directory aren't walked
+	foldersDenied        atomic.Int64
+}
+
+// ProgressSnapshot is the state of a Progress at a point in time, as reported
+// by the status endpoint and the run summary.
+type ProgressSnapshot struct {
+	State                string     `json:"state"`
+	StartedAt            time.Time  `json:"startedAt"`
+	FinishedAt           *time.Time `json:"finishedAt,omitempty"`
+	ElapsedSeconds       float64    `json:"elapsedSeconds"`
+	FilesTotal           *int64     `json:"filesTotal"` // null until counted
+	BytesTotal           *int64     `json:"bytesTotal"` // null until counted
+	Files                int64      `json:"files"`
+	Bytes                int64      `json:"bytes"`
+	Messages             int64      `json:"messages"`
+	Parsed               int64      `json:"parsed"`
+	Failed               int64      `json:"failed"`
+	Skipped              int64      `json:"skipped"`
+	Uploaded             int64      `json:"uploaded"`
+	DeadLettered         int64      `json:"deadLettered"`
+	Pending              int64      `json:"pending"`
+	FilesExcluded        int64      `json:"filesExcluded"`
+	FilesTooLarge        int64      `json:"filesTooLarge"`
+	FilesInDeniedFolders int64      `json:"filesInDeniedFolders"`
+	FoldersDenied        int64      `json:"foldersDenied"`
+	MessagesPerSec       float64    `json:"messagesPerSecond"`
+	BytesPerSec          float64    `json:"bytesPerSecond"`
+	EtaSeconds           *float64   `json:"etaSeconds"` // null until the files are counted, or once the run ends
+}
+
+// currentProgress is the progress of the last indexing run of the process.
+var currentProgress atomic.Pointer[Progress]
+
+// newProgress starts the progress of a run, and makes it the one reported by StatusHandler.
+func newProgress() *Progress {
+	progress := &Progress{started: time.Now()}
+	progress.state.Store(RunStateRunning)
+	progress.filesTotal.Store(-1)
+	progress.bytesTotal.Store(-1)
+	currentProgress.Store(progress)
+	return progress
+}
+
+// countFiles counts the files of the emails directory that pass the filter and their size,
+// as the totals of the run. It's meant to run in the background, while the directory is walked.
+func (progress *Progress) countFiles(dir string, maildir bool, filter *FileFilter) {
+	if progress == nil {
+		return
+	}
+	var files, bytes int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if maildir && entry.Name() == "tmp" {
+				return filepath.SkipDir
+			}
+			if path != dir && filter.skipFolder(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			// the emails directory is a single file
+			relPath = filepath.Base(dir)
+		}
+		if filter.skipFile(filepath.ToSlash(relPath), info.Size()) != "" {
+			return nil
+		}
+		files++
+		bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		log.Printf("WARN: failed to count the files of %v, the time left is unknown: %v", dir, err)
+		return
+	}
+	progress.setTotals(files, bytes)
+}
+
+// setTotals sets the number of files of the run and their size.
+func (progress *Progress) setTotals(files int64, bytes int64) {
+	if progress == nil {
+		return
+	}
+	progress.bytesTotal.Store(bytes)
+	progress.filesTotal.Store(files)
+}
+
+// addFile counts a file walked, whose messages were all sent to the parsers.
+func (progress *Progress) addFile(size int64) {
+	if progress == nil {
+		return
+	}
+	progress.files.Add(1)
+	progress.bytes.Add(size)
+}
+
+// addBytes counts bytes of a file being read, before the file is walked.
+func (progress *Progress) addBytes(n int64) {
+	if progress == nil {
+		return
+	}
+	progress.bytes.Add(n)
+}
+
+// addSkippedFile counts a file skipped by the filter of the run, for the given reason.
+func (progress *Progress) addSkippedFile(reason string) {
+	if progress == nil {
+		return
+	}
+	switch reason {
+	case skipReasonExcluded:
+		progress.filesExcluded.Add(1)
+	case skipReasonTooLarge:
+		progress.filesTooLarge.Add(1)
+	case skipReasonFolder:
+		progress.filesInDeniedFolders.Add(1)
+	}
+}
+
+// addDeniedFolder counts a folder skipped by the filter of the run.
+func (progress *Progress) addDeniedFolder() {
+	if progress == nil {
+		return
+	}
+	progress.foldersDenied.Add(1)
+}
+
+// addMessage counts a message received by a parser, with how it ended: parsed,
+// failed or skipped (one of them is true).
+func (progress *Progress) addMessage(parsed bool, failed bool, skipped bool) {
+	if progress == nil {
+		return
+	}
+	progress.messages.Add(1)
+	switch {
+	case parsed:
+		progress.parsed.Add(1)
+	case failed:
+		progress.failed.Add(1)
+	case skipped:
+		progress.skipped.Add(1)
+	}
+}
+
+// addUploaded counts emails uploaded to zinc.
+func (progress *Progress) addUploaded(n int) {
+	if progress == nil {
+		return
+	}
+	progress.uploaded.Add(int64(n))
+}
+
+// addDeadLettered counts an email that failed to upload.
+func (progress *Progress) addDeadLettered() {
+	if progress == nil {
+		return
+	}
+	progress.deadLettered.Add(1)
+}
+
+// addPending counts emails that failed to upload with a temporary error.
+func (progress *Progress) addPending(n int) {
+	if progress == nil {
+		return
+	}
+	progress.pending.Add(int64(n))
+}
+
+// finish ends the run with the given state.
+func (progress *Progress) finish(state string) {
+	if progress == nil {
+		return
+	}
+	now := time.Now()
+	progress.finished.Store(&now)
+	progress.state.Store(state)
+}
+
+// Snapshot returns the state of the progress.
+func (progress *Progress) Snapshot() *ProgressSnapshot {
+	snapshot := &ProgressSnapshot{
+		State:        progress.state.Load().(string),
+		StartedAt:    progress.started,
+		FinishedAt:   progress.finished.Load(),
+		Files:        progress.files.Load(),
+		Bytes:        progress.bytes.Load(),
+		Messages:     progress.messages.Load(),
+		Parsed:       progress.parsed.Load(),
+		Failed:       progress.failed.Load(),
+		Skipped:      progress.skipped.Load(),
+		Uploaded:     progress.uploaded.Load(),
+		DeadLettered: progress.deadLettered.Load(),
+		Pending:      progress.pending.Load(),
+
+		FilesExcluded:        progress.filesExcluded.Load(),
+		FilesTooLarge:        progress.filesTooLarge.Load(),
+		FilesInDeniedFolders: progress.filesInDeniedFolders.Load(),
+		FoldersDenied:        progress.foldersDenied.Load(),
+	}
+	end := time.Now()
+	if snapshot.FinishedAt != nil {
+		end = *snapshot.FinishedAt
+	}
+	elapsed := end.Sub(progress.started).Seconds()
+	snapshot.ElapsedSeconds = elapsed
+	if elapsed > 0 {
+		snapshot.MessagesPerSec = float64(snapshot.Messages) / elapsed
+		snapshot.BytesPerSec = float64(snapshot.Bytes) / elapsed
+	}
+
+	if files := progress.filesTotal.Load(); files >= 0 {
+		bytes := progress.bytesTotal.Load()
+		snapshot.FilesTotal, snapshot.BytesTotal = &files, &bytes
+		// the time left is estimated from the rate at which the bytes of the files are processed
+		if snapshot.State == RunStateRunning && snapshot.BytesPerSec > 0 {
+			eta := float64(bytes-snapshot.Bytes) / snapshot.BytesPerSec
+			if eta < 0 {
+				eta = 0
+			}
+			snapshot.EtaSeconds = &eta
+		}
+	}
+	return snapshot
+}
+
+// log logs the progress.
+func (progress *Progress) log() {
+	snapshot := progress.Snapshot()
+	total, eta := "?", "ETA unknown"
+	if snapshot.FilesTotal != nil {
+		total = strconv.FormatInt(*snapshot.FilesTotal, 10)
+	}
+	if snapshot.EtaSeconds != nil {
+		eta = "ETA " + (time.Duration(*snapshot.EtaSeconds) * time.Second).String()
+	}
+	if snapshot.State != RunStateRunning {
+		eta = fmt.Sprintf("%v in %v", snapshot.State, time.Duration(snapshot.ElapsedSeconds*float64(time.Second)).Round(time.Second))
+	}
+	log.Printf("INFO: progress: %v/%v files (%.1f MB), %d messages (%d parsed, %d failed, %d skipped), %d uploaded, %d dead-lettered, %d pending, %.0f messages/s, %.2f MB/s, %v",
+		snapshot.Files, total, float64(snapshot.Bytes)/1e6, snapshot.Messages, snapshot.Parsed, snapshot.Failed, snapshot.Skipped,
+		snapshot.Uploaded, snapshot.DeadLettered, snapshot.Pending, snapshot.MessagesPerSec, snapshot.BytesPerSec/1e6, eta)
+	if snapshot.FilesExcluded+snapshot.FilesTooLarge+snapshot.FilesInDeniedFolders+snapshot.FoldersDenied > 0 {
+		log.Printf("INFO: filtered: %d files excluded, %d files too large, %d folders denied (and %d files in denied folders of archives or changes)",
+			snapshot.FilesExcluded, snapshot.FilesTooLarge, snapshot.FoldersDenied, snapshot.FilesInDeniedFolders)
+	}
+}
+
+// logProgress logs the progress every interval, until done is closed.
+func logProgress(progress *Progress, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			progress.log()
+		case <-done:
+			return
+		}
+	}
+}
+
+// saveSummary writes the final snapshot of a run to path, as JSON.
+func (progress *Progress) saveSummary(path string) error {
+	content, err := json.MarshalIndent(progress.Snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// write to a temporary file first so a crash never leaves a partial summary
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// StatusHandler responds with the progress of the last indexing run of the process, as JSON.
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	progress := currentProgress.Load()
+	if progress == nil {
+		http.Error(w, "no indexing run started yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(progress.Snapshot())
+}
@@ -0,0 +1,252 @@
+package routines
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/amoralesc/email-indexer/indexer/email"
+)
+
+// QuarantineEntry is a message that failed to parse, with a copy of it in the quarantine.
+type QuarantineEntry struct {
+	Source   string    `json:"source"`            // see emailSource.String
+	Archive  string    `json:"archive,omitempty"` // the source of the message, to index it as if it was found there
+	Path     string    `json:"path"`
+	Offset   int64     `json:"offset,omitempty"`
+	ModTime  time.Time `json:"modTime"`
+	Mbox     bool      `json:"mbox,omitempty"`
+	Maildir  bool      `json:"maildir,omitempty"`
+	Class    string    `json:"class"` // see email.ErrorClass
+	Error    string    `json:"error"`
+	File     string    `json:"file"` // copy of the message, relative to the quarantine directory
+	FailedAt time.Time `json:"failedAt"`
+}
+
+// QuarantineReport is the report of the messages in the quarantine.
+type QuarantineReport struct {
+	Total   int                `json:"total"`
+	ByClass map[string]int     `json:"byClass"`
+	Entries []*QuarantineEntry `json:"entries"`
+}
+
+// Quarantine keeps the messages that failed to parse in a directory: a copy (or
+// hard link) of each message and a report with the error it failed with. The
+// messages are kept across runs until they parse, so they can be retried after
+// a parser fix (see RetryQuarantine).
+type Quarantine struct {
+	Dir     string
+	mu      sync.Mutex
+	entries map[string]*QuarantineEntry // source -> entry
+}
+
+const (
+	quarantineReportFile  = "report.json"
+	quarantineMessagesDir = "messages"
+)
+
+// LoadQuarantine loads the quarantine kept in dir. If there isn't one, it's empty.
+func LoadQuarantine(dir string) (*Quarantine, error) {
+	quarantine := &Quarantine{Dir: dir, entries: map[string]*QuarantineEntry{}}
+
+	content, err := os.ReadFile(filepath.Join(dir, quarantineReportFile))
+	if os.IsNotExist(err) {
+		return quarantine, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var report QuarantineReport
+	if err := json.Unmarshal(content, &report); err != nil {
+		return nil, err
+	}
+	for _, entry := range report.Entries {
+		quarantine.entries[entry.Source] = entry
+	}
+	return quarantine, nil
+}
+
+// add quarantines the message of a source that failed to parse with err.
+func (quarantine *Quarantine) add(source *emailSource, err error) {
+	key := source.String()
+	hash := sha256.Sum256([]byte(key))
+	entry := &QuarantineEntry{
+		Source:   key,
+		Archive:  source.archive,
+		Path:     filepath.ToSlash(source.path),
+		Offset:   source.offset,
+		ModTime:  source.modTime,
+		Mbox:     source.mbox,
+		Maildir:  source.maildir,
+		Class:    email.ErrorClass(err),
+		Error:    err.Error(),
+		File:     quarantineMessagesDir + "/" + hex.EncodeToString(hash[:16]) + ".eml",
+		FailedAt: time.Now().UTC(),
+	}
+
+	if entry.ModTime.IsZero() {
+		if info, statErr := os.Stat(source.file); statErr == nil {
+			entry.ModTime = info.ModTime()
+		}
+	}
+
+	// a retried message is already in the quarantine
+	path := filepath.Join(quarantine.Dir, filepath.FromSlash(entry.File))
+	if source.file != path {
+		if copyErr := quarantine.copyMessage(source, path); copyErr != nil {
+			log.Printf("WARN: failed to quarantine %v: %v", source, copyErr)
+			entry.File = ""
+		}
+	}
+
+	quarantine.mu.Lock()
+	defer quarantine.mu.Unlock()
+	quarantine.entries[key] = entry
+}
+
+// copyMessage saves the message of a source to path. A message that is a whole
+// file is hard linked, or copied if the link fails (e.g. across file systems).
+func (quarantine *Quarantine) copyMessage(source *emailSource, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	os.Remove(path)
+
+	if source.content != nil {
+		if err := os.WriteFile(path, source.content, 0644); err != nil {
+			return err
+		}
+		// the modification time may be the last fallback of the date
+		return os.Chtimes(path, source.modTime, source.modTime)
+	}
+
+	if err := os.Link(source.file, path); err == nil {
+		return nil
+	}
+	return copyFile(source.file, path)
+}
+
+// copyFile copies the file located at src to dst, keeping its modification time.
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// resolve removes the message of a source that parsed from the quarantine, if it's there.
+func (quarantine *Quarantine) resolve(source *emailSource) {
+	quarantine.mu.Lock()
+	defer quarantine.mu.Unlock()
+
+	key := source.String()
+	entry, ok := quarantine.entries[key]
+	if !ok {
+		return
+	}
+	if entry.File != "" {
+		os.Remove(filepath.Join(quarantine.Dir, filepath.FromSlash(entry.File)))
+	}
+	delete(quarantine.entries, key)
+}
+
+// Report returns the report of the messages in the quarantine, sorted by source.
+func (quarantine *Quarantine) Report() *QuarantineReport {
+	quarantine.mu.Lock()
+	defer quarantine.mu.Unlock()
+
+	report := &QuarantineReport{ByClass: map[string]int{}, Entries: []*QuarantineEntry{}}
+	for _, entry := range quarantine.entries {
+		report.Total++
+		report.ByClass[entry.Class]++
+		report.Entries = append(report.Entries, entry)
+	}
+	sort.Slice(report.Entries, func(i, j int) bool {
+		return report.Entries[i].Source < report.Entries[j].Source
+	})
+	return report
+}
+
+// Save writes the report of the quarantine to its directory.
+func (quarantine *Quarantine) Save() error {
+	content, err := json.MarshalIndent(quarantine.Report(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(quarantine.Dir, 0755); err != nil {
+		return err
+	}
+	// write to a temporary file first so a crash never leaves a partial report
+	path := filepath.Join(quarantine.Dir, quarantineReportFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// sources returns the sources of the quarantined messages, read from their copies.
+func (quarantine *Quarantine) sources() []*emailSource {
+	quarantine.mu.Lock()
+	defer quarantine.mu.Unlock()
+
+	var sources []*emailSource
+	for _, entry := range quarantine.entries {
+		if entry.File == "" {
+			log.Printf("WARN: %v has no copy in the quarantine, skipping it", entry.Source)
+			continue
+		}
+		sources = append(sources, &emailSource{
+			file:    filepath.Join(quarantine.Dir, filepath.FromSlash(entry.File)),
+			archive: entry.Archive,
+			path:    filepath.FromSlash(entry.Path),
+			offset:  entry.Offset,
+			modTime: entry.ModTime,
+			mbox:    entry.Mbox,
+			maildir: entry.Maildir,
+		})
+	}
+	return sources
+}
+
+// logQuarantineReport logs the summary of the quarantine report.
+func logQuarantineReport(report *QuarantineReport, dir string) {
+	if report.Total == 0 {
+		log.Println("INFO: all messages parsed, the quarantine is empty")
+		return
+	}
+	classes := make([]string, 0, len(report.ByClass))
+	for class := range report.ByClass {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	log.Printf("WARN: %d messages failed to parse and are quarantined at %v", report.Total, dir)
+	for _, class := range classes {
+		log.Printf("WARN:   %v: %d", class, report.ByClass[class])
+	}
+}
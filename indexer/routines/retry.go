@@ -0,0 +1,69 @@
+package routines
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// RetryConfig sets how failed uploads are retried. The backoff before the nth
+// retry is a random duration up to InitialBackoff * 2^(n-1), capped at MaxBackoff.
+type RetryConfig struct {
+	MaxRetries     int           // number of retries after the first attempt
+	InitialBackoff time.Duration // backoff cap before the first retry
+	MaxBackoff     time.Duration // backoff cap of every retry
+}
+
+// backoff returns the time to wait before the given retry (starting at 1), with full jitter.
+func (config *RetryConfig) backoff(retry int) time.Duration {
+	limit := config.InitialBackoff
+	for i := 1; i < retry && limit < config.MaxBackoff; i++ {
+		limit *= 2
+	}
+	if limit > config.MaxBackoff {
+		limit = config.MaxBackoff
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit) + 1))
+}
+
+// temporaryError is an error that tells whether the request that failed may succeed
+// if it's sent again, like zinc.ResponseError.
+type temporaryError interface {
+	error
+	IsTemporary() bool
+}
+
+// isRetryable returns true if an upload that failed with err may succeed if it's sent again.
+// Requests rejected by the index (4xx responses) fail again, while network errors and 5xx don't have to.
+func isRetryable(err error) bool {
+	var responseErr temporaryError
+	if errors.As(err, &responseErr) {
+		return responseErr.IsTemporary()
+	}
+	return true
+}
+
+// withRetries calls operation until it succeeds, it fails with an error that isn't
+// retryable, or the retries are exhausted. It returns the error of the last attempt,
+// or the error of ctx if it's done while waiting to retry.
+func withRetries(ctx context.Context, config *RetryConfig, operation func() error) error {
+	err := operation()
+	for retry := 1; err != nil && retry <= config.MaxRetries && isRetryable(err) && ctx.Err() == nil; retry++ {
+		backoff := config.backoff(retry)
+		log.Printf("WARN: upload failed, retrying in %v (%d/%d): %v", backoff, retry, config.MaxRetries, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		err = operation()
+	}
+	return err
+}
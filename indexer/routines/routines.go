@@ -1,108 +1,413 @@
 package routines
 
 import (
-	"io/fs"
+	"context"
 	"log"
 	"path/filepath"
 	"sync"
+	"time"
 
+	"github.com/amoralesc/email-indexer/indexer/attachments"
 	"github.com/amoralesc/email-indexer/indexer/email"
-	"github.com/amoralesc/email-indexer/indexer/zinc"
+	"github.com/amoralesc/email-indexer/indexer/store"
 )
 
-// parseEmailFiles is a routine that parses emails from a channel of file paths
-// and sends them to a channel of emails.
-func parseEmailFiles(files <-chan string, emails chan<- *email.Email) {
-	for file := range files {
-		emailObj, err := email.EmailFromFile(file)
+// parseEmails is a routine that parses emails from a channel of sources
+// and sends them to a channel of emails. The attachments of each email
+// are saved to the attachment store. The sources already uploaded by
+// a previous run (see Checkpoint) are skipped, and the ones that fail
+// to parse are quarantined (or counted, in a dry run). Once ctx is done,
+// the sources left are skipped.
+func parseEmails(ctx context.Context, sources <-chan *emailSource, emails chan<- *email.Email, config *IndexerConfig) {
+	for source := range sources {
+		if ctx.Err() != nil {
+			continue
+		}
+		if isCheckpointed(source, config) {
+			config.progress.addMessage(false, false, true)
+			continue
+		}
+		emailObj, err := source.parse()
+		config.progress.addMessage(err == nil, err != nil, false)
 		if err != nil {
-			log.Printf("WARN: failed to parse %v: %v", file, err)
+			log.Printf("WARN: failed to parse %v: %v", source, err)
+			if config.Quarantine != nil {
+				config.Quarantine.add(source, err)
+			}
+			if config.DryRun != nil {
+				config.DryRun.addFailure(err)
+			}
 		} else {
+			if config.Quarantine != nil {
+				config.Quarantine.resolve(source)
+			}
+			saveAttachments(emailObj, config.AttachmentStore)
 			emails <- emailObj
 		}
 	}
 }
 
-// uploadEmails is a routine that uploads emails from a channel of emails to zinc.
-func uploadEmails(emails <-chan *email.Email, bulkUploadSize int, zincAuth *zinc.ZincAuth) {
-	bulk := &zinc.BulkEmails{
-		Index:   "emails",
-		Records: make([]email.Email, bulkUploadSize),
+// isCheckpointed returns true if the email of the source was uploaded by a previous
+// run that didn't finish. Its id is kept in the manifest, as if it was uploaded again.
+func isCheckpointed(source *emailSource, config *IndexerConfig) bool {
+	if config.Checkpoint == nil {
+		return false
+	}
+	id, ok := config.Checkpoint.isDone(checkpointKey(source.archive, source.path, source.offset))
+	if ok && config.Manifest != nil {
+		key := source.archive
+		if key == "" {
+			key = filepath.ToSlash(source.path)
+		}
+		config.Manifest.addIds(key, id)
+	}
+	return ok
+}
+
+// saveAttachments saves the content of the email attachments to the attachment store
+// and releases it from the email, since it isn't uploaded to zinc. Without an
+// attachment store (in a dry run), the content is only released.
+func saveAttachments(emailObj *email.Email, attachmentStore *attachments.AttachmentStore) {
+	for i := range emailObj.Attachments {
+		attachment := &emailObj.Attachments[i]
+		if attachmentStore != nil {
+			err := attachmentStore.Save(attachment.Hash, attachment.Content)
+			if err != nil {
+				log.Printf("WARN: failed to save attachment %v of %v: %v", attachment.Filename, emailObj.MessageId, err)
+			}
+		}
+		attachment.Content = nil
 	}
+}
+
+// uploadEmails is a routine that uploads emails from a channel of emails to the index.
+// Once a batch is acknowledged, it's recorded in the manifest and checkpoint (if any).
+// The uploads in progress when ctx is done are canceled.
+func uploadEmails(ctx context.Context, emails <-chan *email.Email, config *IndexerConfig) {
+	records := make([]store.EmailWithId, config.BulkUploadSize)
+	batch := make([]*email.Email, config.BulkUploadSize)
 	parsed := 0
 	total := 0
 	// upload emails in batches of bulkUploadSize
 	for emailObj := range emails {
-		bulk.Records[parsed] = *emailObj
+		records[parsed] = *store.NewEmailWithId(emailObj.DocumentId(), emailObj)
+		batch[parsed] = emailObj
 		parsed++
-		if parsed == bulkUploadSize {
+		if parsed == config.BulkUploadSize {
 			log.Printf("TRACE: uploading %d emails\n", parsed)
-			err := zinc.UploadEmails(bulk, zincAuth)
-			if err != nil {
-				log.Fatal("FATAL: failed to upload emails: ", err)
-			}
-			total += parsed
+			total += uploadBatch(ctx, batch, records, config)
 			parsed = 0
 		}
 	}
 	if parsed > 0 {
-		bulk.Records = bulk.Records[:parsed]
-		err := zinc.UploadEmails(bulk, zincAuth)
-		if err != nil {
-			log.Fatal("FATAL: failed to upload emails: ", err)
-		}
-		total += parsed
+		total += uploadBatch(ctx, batch[:parsed], records[:parsed], config)
 	}
 	log.Printf("INFO: goroutine uploaded %d emails, exitting\n", total)
 }
 
-// ParseAndUploadEmails is the goroutine manager. It spawns a number of
-// goroutines to parse emails from files and upload them to zinc.
-func ParseAndUploadEmails(dir string, numUploaderWorkers int, numParserWorkers int, bulkUploadSize int, zincAuth *zinc.ZincAuth) {
-	// create channels for passing data between goroutines
-	files := make(chan string)
-	emails := make(chan *email.Email)
+// uploadBatch uploads a batch of emails to the index, retrying it with backoff if it fails.
+// A batch rejected by the index is split in halves (retried the same way) until the emails
+// the index doesn't accept are isolated and sent to the dead-letter queue. A batch that
+// still fails with a temporary error (like when the index is down) isn't acknowledged:
+// it's left pending for the next run (see Checkpoint), as a canceled batch is.
+// It returns the number of emails uploaded.
+func uploadBatch(ctx context.Context, batch []*email.Email, records []store.EmailWithId, config *IndexerConfig) int {
+	upload := func() error {
+		return config.Index.IndexEmails(ctx, records)
+	}
+	err := withRetries(ctx, &config.Retry, upload)
+	switch {
+	case err == nil:
+		acknowledgeBatch(batch, records, config)
+		return len(records)
+	case ctx.Err() != nil:
+		log.Printf("WARN: upload of %d emails canceled: %v", len(records), err)
+		return 0
+	case isRetryable(err):
+		log.Printf("ERROR: failed to upload %d emails, leaving them for the next run: %v", len(records), err)
+		config.progress.addPending(len(records))
+		return 0
+	default:
+		return splitBatch(ctx, batch, records, config, err)
+	}
+}
 
-	// spawn uploader goroutines
-	log.Printf("TRACE: spawning %d uploader goroutines", numUploaderWorkers)
-	var wgUploaders sync.WaitGroup
-	for i := 0; i < numUploaderWorkers; i++ {
-		wgUploaders.Add(1)
-		go func() {
-			defer wgUploaders.Done()
-			uploadEmails(emails, bulkUploadSize, zincAuth)
-		}()
+// splitBatch uploads the halves of a batch that was rejected with err, splitting them
+// again while they are rejected. It returns the number of emails uploaded.
+func splitBatch(ctx context.Context, batch []*email.Email, records []store.EmailWithId, config *IndexerConfig, err error) int {
+	if len(records) == 1 {
+		deadLetter(records[0], err, config)
+		return 0
 	}
 
-	// spawn file parser goroutines
-	log.Printf("TRACE: spawning %d parser goroutines", numParserWorkers)
-	var wgParsers sync.WaitGroup
-	for i := 0; i < numParserWorkers; i++ {
-		wgParsers.Add(1)
-		go func() {
-			defer wgParsers.Done()
-			parseEmailFiles(files, emails)
-		}()
+	log.Printf("WARN: %d emails rejected, splitting the batch: %v", len(records), err)
+	half := len(records) / 2
+	return uploadBatch(ctx, batch[:half], records[:half], config) +
+		uploadBatch(ctx, batch[half:], records[half:], config)
+}
+
+// deadLetter sends an email that failed to upload with err to the dead-letter queue.
+func deadLetter(record store.EmailWithId, err error, config *IndexerConfig) {
+	log.Printf("ERROR: failed to upload email %v (%v): %v", record.Id, record.SourcePath, err)
+	config.progress.addDeadLettered()
+	if config.DeadLetters == nil {
+		return
 	}
+	if err := config.DeadLetters.Add(record, err); err != nil {
+		log.Printf("ERROR: failed to save email %v to the dead-letter queue: %v", record.Id, err)
+	}
+}
 
-	// walk directory and send file paths to channel
-	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
-		if err != nil {
+// acknowledgeBatch records a batch of emails acknowledged by the index in the manifest
+// and checkpoint of the run, if they are enabled.
+func acknowledgeBatch(batch []*email.Email, records []store.EmailWithId, config *IndexerConfig) {
+	config.progress.addUploaded(len(batch))
+	if config.Manifest != nil {
+		for i, emailObj := range batch {
+			config.Manifest.addIds(manifestKey(emailObj), records[i].Id)
+		}
+	}
+	if config.Checkpoint != nil {
+		keys := make([]string, len(batch))
+		ids := make([]string, len(batch))
+		for i, emailObj := range batch {
+			keys[i] = checkpointKey(emailObj.SourceArchive, emailObj.SourcePath, emailObj.SourceOffset)
+			ids[i] = records[i].Id
+		}
+		if err := config.Checkpoint.add(keys, ids); err != nil {
+			log.Printf("WARN: failed to save checkpoint: %v", err)
+		}
+	}
+}
+
+// manifestKey returns the key of the file an email was indexed from in the manifest.
+// Emails found in an archive belong to the archive file.
+func manifestKey(emailObj *email.Email) string {
+	if emailObj.SourceArchive != "" {
+		return emailObj.SourceArchive
+	}
+	return emailObj.SourcePath
+}
+
+// deleteStaleEmails deletes the emails indexed by the previous runs whose files
+// were removed or changed, in batches of bulkSize.
+func deleteStaleEmails(ctx context.Context, ids []string, bulkSize int, index store.EmailIndex) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	log.Printf("INFO: deleting %d emails of removed or changed files", len(ids))
+	for start := 0; start < len(ids); start += bulkSize {
+		end := start + bulkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if err := index.DeleteEmails(ctx, ids[start:end]); err != nil {
 			return err
 		}
-		if !entry.IsDir() {
-			files <- path
+	}
+	return nil
+}
+
+// IndexerConfig sets the parameters of an indexing run.
+type IndexerConfig struct {
+	Dir                string                       // the emails directory (or archive)
+	Maildir            bool                         // if true, Dir is read as a Maildir (or a tree of them)
+	Filter             *FileFilter                  // if not nil, the files it skips aren't indexed
+	NumUploaderWorkers int                          // number of goroutines uploading emails to the index
+	NumParserWorkers   int                          // number of goroutines parsing emails
+	BulkUploadSize     int                          // number of emails uploaded in a single request
+	Index              store.EmailIndex             // the index to upload the emails to (zinc by default)
+	AttachmentStore    *attachments.AttachmentStore // where the content of the attachments is saved
+	Manifest           *Manifest                    // if not nil, only new or changed files are indexed
+	Checkpoint         *Checkpoint                  // if not nil, the sources it has are skipped and the uploaded ones are added
+	Retry              RetryConfig                  // how failed uploads are retried
+	DeadLetters        *DeadLetterQueue             // where the emails that failed to upload are saved
+	Quarantine         *Quarantine                  // where the messages that failed to parse are kept
+	ShutdownTimeout    time.Duration                // how long the batches in progress can take to upload once the run is canceled
+	ProgressInterval   time.Duration                // how often the progress is logged, never if 0
+	SummaryPath        string                       // where the summary of the run is saved as JSON, if not empty
+	DryRun             *DryRun                      // if not nil, the emails are counted in it instead of uploaded
+	Export             *EmailWriter                 // if not nil, the emails are written to it instead of uploaded
+
+	progress *Progress // the progress of the run in progress
+}
+
+// ParseAndUploadEmails is the goroutine manager. It spawns a number of
+// goroutines to parse emails from files and upload them to zinc.
+// If the run is resumed from a checkpoint, the emails already uploaded are skipped.
+// In incremental mode (with a manifest), the files that didn't change since
+// the previous runs are skipped, and the emails of removed files are deleted.
+// If ctx is done before the run finishes, the walk stops and the batches in progress
+// are uploaded. The manifest isn't saved then, and the checkpoint is kept to resume the run.
+// The same happens if any batch was left pending (see uploadBatch).
+func ParseAndUploadEmails(ctx context.Context, config *IndexerConfig) {
+	runPipeline(ctx, config, func(sources chan<- *emailSource) error {
+		go config.progress.countFiles(config.Dir, config.Maildir, config.Filter)
+		return walkEmailsDir(ctx, config.Dir, config.Maildir, config.Filter, config.Manifest, config.progress, sources)
+	})
+	if ctx.Err() != nil {
+		log.Println("INFO: indexing canceled, the next run resumes it")
+		return
+	}
+	if pending := config.progress.Snapshot().Pending; pending > 0 {
+		log.Printf("INFO: %d emails failed to upload, the next run resumes it", pending)
+		return
+	}
+
+	if config.Manifest != nil {
+		if err := deleteStaleEmails(ctx, config.Manifest.staleIds(), config.BulkUploadSize, config.Index); err != nil {
+			log.Fatal("FATAL: failed to delete emails of removed files: ", err)
+		}
+		if err := config.Manifest.Save(); err != nil {
+			log.Fatal("FATAL: failed to save manifest: ", err)
+		}
+	}
+	// the run finished, the next one starts over
+	if config.Checkpoint != nil {
+		if err := config.Checkpoint.Remove(); err != nil {
+			log.Printf("WARN: failed to remove checkpoint: %v", err)
+		}
+	}
+}
+
+// RetryQuarantine parses the messages in the quarantine again (e.g. after a parser fix)
+// and uploads the ones that parse, as if they were found in their original sources.
+// The messages that still fail stay in the quarantine, with their new errors.
+// If the config has a manifest, the ids of the emails uploaded are added to their
+// source files in it, so they are deleted when the files are removed or changed.
+func RetryQuarantine(ctx context.Context, config *IndexerConfig) {
+	retried := config.Quarantine.sources()
+	log.Printf("INFO: retrying %d quarantined messages", len(retried))
+	if config.Manifest != nil {
+		config.Manifest.keepAll()
+	}
+	runPipeline(ctx, config, func(sources chan<- *emailSource) error {
+		for _, source := range retried {
+			if err := send(ctx, sources, source); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
-	if err != nil {
+	if ctx.Err() != nil || config.Manifest == nil || config.progress.Snapshot().Pending > 0 {
+		return
+	}
+	if err := config.Manifest.Save(); err != nil {
+		log.Printf("ERROR: failed to save manifest: %v", err)
+	}
+}
+
+// runPipeline spawns the parser and uploader goroutines, sends them the sources
+// found by walk, and waits for them to finish. Then, it saves the quarantine report
+// and the summary of the run (see runUploaders).
+func runPipeline(ctx context.Context, config *IndexerConfig, walk func(sources chan<- *emailSource) error) {
+	err := runUploaders(ctx, config, func(emails chan<- *email.Email) error {
+		// create channel for passing sources to the parsers
+		sources := make(chan *emailSource)
+
+		// spawn file parser goroutines
+		log.Printf("TRACE: spawning %d parser goroutines", config.NumParserWorkers)
+		var wgParsers sync.WaitGroup
+		for i := 0; i < config.NumParserWorkers; i++ {
+			wgParsers.Add(1)
+			go func() {
+				defer wgParsers.Done()
+				parseEmails(ctx, sources, emails, config)
+			}()
+		}
+
+		// walk directory and send email sources to channel
+		err := walk(sources)
+
+		// close sources channel to signal end of parsing
+		close(sources)
+		wgParsers.Wait()
+
+		if config.Quarantine != nil {
+			logQuarantineReport(config.Quarantine.Report(), config.Quarantine.Dir)
+			if err := config.Quarantine.Save(); err != nil {
+				log.Printf("WARN: failed to save quarantine report: %v", err)
+			}
+		}
+		return err
+	})
+	if err != nil && ctx.Err() == nil {
 		log.Fatal("FATAL: failed to walk directory: ", err)
 	}
+}
+
+// runUploaders spawns the uploader goroutines (or the ones that collect the emails
+// of a dry run or export), sends them the emails produced, and waits for them to finish.
+// Then, it saves the summary of the run. It returns the error produce failed with.
+// The progress of the run is logged while it's running. Once ctx is done, the uploaders
+// have the shutdown timeout to upload their batches.
+func runUploaders(ctx context.Context, config *IndexerConfig, produce func(emails chan<- *email.Email) error) error {
+	// create channel for passing emails to the uploaders
+	emails := make(chan *email.Email)
+
+	config.progress = newProgress()
+	if config.ProgressInterval > 0 {
+		stopLogging := make(chan struct{})
+		defer close(stopLogging)
+		go logProgress(config.progress, config.ProgressInterval, stopLogging)
+	}
+
+	// the uploads outlive ctx, to flush the batches in progress
+	uploadCtx, cancelUploads := context.WithCancel(context.Background())
+	defer cancelUploads()
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			log.Printf("INFO: canceled, uploading the batches in progress (timeout %v)", config.ShutdownTimeout)
+		case <-finished:
+			return
+		}
+		timer := time.NewTimer(config.ShutdownTimeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancelUploads()
+		case <-finished:
+		}
+	}()
+
+	// spawn uploader goroutines (or the collectors of a dry run or export)
+	log.Printf("TRACE: spawning %d uploader goroutines", config.NumUploaderWorkers)
+	var wgUploaders sync.WaitGroup
+	for i := 0; i < config.NumUploaderWorkers; i++ {
+		wgUploaders.Add(1)
+		go func() {
+			defer wgUploaders.Done()
+			switch {
+			case config.DryRun != nil:
+				config.DryRun.collectEmails(emails, config.Export)
+			case config.Export != nil:
+				config.Export.writeEmails(emails, config.progress)
+			default:
+				uploadEmails(uploadCtx, emails, config)
+			}
+		}()
+	}
+
+	err := produce(emails)
 
-	// close files channel to signal end of parsing
-	close(files)
-	wgParsers.Wait()
 	// close emails channel to signal end of uploading
 	close(emails)
 	wgUploaders.Wait()
+
+	if ctx.Err() != nil {
+		config.progress.finish(RunStateCanceled)
+	} else {
+		config.progress.finish(RunStateFinished)
+	}
+	config.progress.log()
+	if config.SummaryPath != "" {
+		if err := config.progress.saveSummary(config.SummaryPath); err != nil {
+			log.Printf("WARN: failed to save run summary: %v", err)
+		}
+	}
+	return err
 }
@@ -0,0 +1,230 @@
+package routines
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/amoralesc/email-indexer/indexer/email"
+	"github.com/amoralesc/email-indexer/indexer/mbox"
+)
+
+// emailSource is a message found by a walker, waiting to be parsed.
+type emailSource struct {
+	file    string    // path of the file to read the message from, if content is nil
+	archive string    // path of the archive the source file is in, relative to the emails directory
+	path    string    // path of the source file, relative to the emails directory (or archive)
+	offset  int64     // byte offset of the message in the source file
+	modTime time.Time // modification time of the source file
+	content []byte    // the message, if it was already read from the source file
+	mbox    bool      // if true, the source file is an mbox file
+	maildir bool      // if true, the source file is a Maildir message file
+}
+
+// String returns the path of the source, with the archive it's in and
+// the offset of the message if the source file has more than one.
+func (source *emailSource) String() string {
+	name := source.path
+	if source.archive != "" {
+		name = fmt.Sprintf("%v:%v", source.archive, source.path)
+	}
+	if source.mbox {
+		name = fmt.Sprintf("%v@%d", name, source.offset)
+	}
+	return name
+}
+
+// parse parses the message of the source to an Email, with its source set.
+func (source *emailSource) parse() (*email.Email, error) {
+	var emailObj *email.Email
+	var err error
+	if source.content == nil {
+		emailObj, err = email.EmailFromFile(source.file)
+	} else {
+		emailObj, err = email.EmailFromReader(bytes.NewReader(source.content), source.modTime)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if source.maildir {
+		emailObj.SetMaildirSource(source.path)
+	} else {
+		emailObj.SetSource(source.path)
+	}
+	emailObj.SourceArchive = source.archive
+	emailObj.SourceOffset = source.offset
+	return emailObj, nil
+}
+
+// walkEmailsDir walks the emails directory and sends every message found to the
+// sources channel. The emails directory may also be a single file (e.g. an archive).
+// The files (and folders) skipped by the filter aren't sent, nor added to the manifest.
+// If manifest isn't nil, only the new or changed files are sent. The walk stops when ctx is done.
+// The files walked and skipped are counted in progress.
+func walkEmailsDir(ctx context.Context, dir string, maildir bool, filter *FileFilter, manifest *Manifest, progress *Progress, sources chan<- *emailSource) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		if reason := filter.skipFile(filepath.Base(dir), info.Size()); reason != "" {
+			progress.addSkippedFile(reason)
+			return nil
+		}
+		if isFileChanged(manifest, dir, filepath.Base(dir)) {
+			sendFile(ctx, dir, filepath.Base(dir), maildir, filter, progress, sources)
+		}
+		progress.addFile(info.Size())
+		return ctx.Err()
+	}
+
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			// messages in tmp/ are still being delivered
+			if maildir && entry.Name() == "tmp" {
+				return filepath.SkipDir
+			}
+			if path != dir && filter.skipFolder(entry.Name()) {
+				progress.addDeniedFolder()
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if reason := filter.skipFile(filepath.ToSlash(relPath), info.Size()); reason != "" {
+			progress.addSkippedFile(reason)
+			return nil
+		}
+
+		if isFileChanged(manifest, path, filepath.ToSlash(relPath)) {
+			sendFile(ctx, path, relPath, maildir, filter, progress, sources)
+		}
+		progress.addFile(info.Size())
+		return nil
+	})
+}
+
+// isFileChanged returns true if the file located at path is new or changed since
+// the previous runs, adding it to the manifest. Without a manifest, every file is new.
+func isFileChanged(manifest *Manifest, path string, key string) bool {
+	if manifest == nil {
+		return true
+	}
+	changed, err := manifest.checkFile(path, key)
+	if err != nil {
+		log.Printf("WARN: failed to check %v, indexing it: %v", path, err)
+	}
+	return changed
+}
+
+// sendFile sends the messages of the file located at path to the sources channel.
+// Archives are read entry by entry and mbox files are split into their messages.
+// In maildir mode, only the files in cur/ and new/ directories are messages.
+// relPath is the path of the file relative to the emails directory. The entries of
+// archives skipped by the filter are counted in progress.
+func sendFile(ctx context.Context, path string, relPath string, maildir bool, filter *FileFilter, progress *Progress, sources chan<- *emailSource) {
+	if isArchive(path) {
+		if err := readArchive(ctx, path, relPath, maildir, filter, progress, sources); err != nil && ctx.Err() == nil {
+			log.Printf("WARN: failed to read archive %v: %v", path, err)
+		}
+		return
+	}
+
+	if maildir {
+		if isMaildirMessage(relPath) {
+			send(ctx, sources, &emailSource{file: path, path: relPath, maildir: true})
+		}
+		return
+	}
+
+	isMbox, err := mbox.IsMbox(path)
+	if err != nil {
+		log.Printf("WARN: failed to read %v: %v", path, err)
+		return
+	}
+	if isMbox {
+		if err := readMboxFile(ctx, path, relPath, sources); err != nil && ctx.Err() == nil {
+			log.Printf("WARN: failed to read mbox %v: %v", path, err)
+		}
+		return
+	}
+
+	send(ctx, sources, &emailSource{file: path, path: relPath})
+}
+
+// send sends a source to the sources channel, unless ctx is done first.
+func send(ctx context.Context, sources chan<- *emailSource, source *emailSource) error {
+	select {
+	case sources <- source:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isMaildirMessage returns true if the file located at path is in a cur/ or new/ directory.
+func isMaildirMessage(filePath string) bool {
+	parent := path.Base(path.Dir(filepath.ToSlash(filePath)))
+	return parent == "cur" || parent == "new"
+}
+
+// readMboxFile splits the mbox file located at path into messages and sends them
+// to the sources channel. relPath is the path of the file relative to the emails directory.
+func readMboxFile(ctx context.Context, path string, relPath string, sources chan<- *emailSource) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return readMbox(ctx, file, &emailSource{path: relPath, modTime: info.ModTime()}, sources)
+}
+
+// readMbox splits the mbox read from r into messages and sends them to the sources
+// channel. Each message source is a copy of the mbox source, with its offset and content.
+func readMbox(ctx context.Context, r io.Reader, mboxSource *emailSource, sources chan<- *emailSource) error {
+	reader := mbox.NewReader(r)
+	for {
+		msg, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		source := *mboxSource
+		source.mbox = true
+		source.offset = msg.Offset
+		source.content = msg.Content
+		if err := send(ctx, sources, &source); err != nil {
+			return err
+		}
+	}
+}
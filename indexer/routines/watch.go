@@ -0,0 +1,249 @@
+package routines
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchMaxDelayFactor bounds the delay of a change when the events never stop
+// arriving: the pending changes are indexed at most this many debounces after the first.
+const watchMaxDelayFactor = 10
+
+// WatchEmails indexes the files of the emails directory as they are created, modified
+// or deleted, until the watcher fails. It first indexes the files that changed since the
+// previous runs, like an incremental run. Then, the changes are collected until no event
+// arrives for the debounce duration, and pushed through the parser and uploader routines.
+// The emails of deleted files are deleted from zinc, so the config must have a manifest.
+// Watching stops when ctx is done. The pending changes are left for the next start.
+func WatchEmails(ctx context.Context, config *IndexerConfig, debounce time.Duration) error {
+	if config.Manifest == nil {
+		return errors.New("watch mode needs a manifest")
+	}
+	info, err := os.Stat(config.Dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New("watch mode needs an emails directory, not a file")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	// watch before the first run, so the files created during it aren't missed
+	if _, err := watchDir(watcher, config.Dir, config.Maildir, config.Filter); err != nil {
+		return err
+	}
+
+	log.Println("INFO: indexing the files that changed since the previous runs")
+	config.Checkpoint = nil
+	resyncEmails(ctx, config)
+
+	log.Printf("INFO: watching %v for changes", config.Dir)
+	pending := map[string]bool{} // path -> true if it was created or modified, false if it was removed
+	resync := false              // if true, the events overflowed and the whole directory is indexed
+	var first time.Time
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !handleWatchEvent(watcher, event, config.Maildir, config.Filter, pending) {
+				continue
+			}
+			if first.IsZero() {
+				first = time.Now()
+			}
+			// debounce, unless the changes have been waiting for too long
+			if time.Since(first) < debounce*watchMaxDelayFactor {
+				resetTimer(timer, debounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				// events were lost, the next changes may not be seen until the directory is walked again
+				log.Printf("WARN: watcher events overflowed, indexing the whole directory")
+				resync = true
+				resetTimer(timer, debounce)
+				continue
+			}
+			return err
+		case <-timer.C:
+			if resync {
+				resyncEmails(ctx, config)
+			} else {
+				indexChanges(ctx, config, pending)
+			}
+			pending = map[string]bool{}
+			resync = false
+			first = time.Time{}
+		}
+	}
+}
+
+// resetTimer resets a timer to fire after duration. If it had already fired, its
+// value is drained first, so the changes aren't indexed before the new duration.
+func resetTimer(timer *time.Timer, duration time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(duration)
+}
+
+// resyncEmails indexes the files of the emails directory that changed since the files
+// in the manifest were indexed, and deletes the emails of the files removed since then.
+// If ctx is done first or any email was left pending, the files indexed by the previous
+// runs are kept instead, so the next changes aren't saved with a partial walk: the
+// resync of the next start indexes the rest.
+func resyncEmails(ctx context.Context, config *IndexerConfig) {
+	config.Manifest.reset()
+	ParseAndUploadEmails(ctx, config)
+	if ctx.Err() != nil || config.progress.Snapshot().Pending > 0 {
+		config.Manifest.keepAll()
+		return
+	}
+	config.Manifest.commitAll()
+}
+
+// watchDir adds dir and its subdirectories to the watcher, and returns the files in them.
+// In maildir mode, tmp/ directories aren't watched, since messages are still being delivered there.
+// The folders denied by the filter aren't watched either.
+func watchDir(watcher *fsnotify.Watcher, dir string, maildir bool, filter *FileFilter) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			files = append(files, path)
+			return nil
+		}
+		if maildir && entry.Name() == "tmp" {
+			return filepath.SkipDir
+		}
+		if path != dir && filter.skipFolder(entry.Name()) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+	return files, err
+}
+
+// handleWatchEvent adds the change of a watcher event to the pending changes, and returns
+// true if there was one. New directories are watched, and their files added as created.
+func handleWatchEvent(watcher *fsnotify.Watcher, event fsnotify.Event, maildir bool, filter *FileFilter, pending map[string]bool) bool {
+	switch {
+	case event.Has(fsnotify.Create):
+		info, err := os.Stat(event.Name)
+		if err != nil {
+			// already removed
+			return false
+		}
+		if info.IsDir() {
+			if filter.skipFolder(info.Name()) {
+				return false
+			}
+			// files may be created before the directory is watched
+			files, err := watchDir(watcher, event.Name, maildir, filter)
+			if err != nil {
+				log.Printf("WARN: failed to watch %v: %v", event.Name, err)
+			}
+			for _, file := range files {
+				pending[file] = true
+			}
+			return true
+		}
+		pending[event.Name] = true
+	case event.Has(fsnotify.Write):
+		pending[event.Name] = true
+	case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+		// a renamed file is created again with its new name
+		pending[event.Name] = false
+	default:
+		return false
+	}
+	return true
+}
+
+// indexChanges indexes the pending changes of the emails directory: the files created or
+// modified are parsed and uploaded (if their content changed), and the emails of the files
+// removed or changed are deleted from zinc. The manifest is saved afterwards, unless ctx
+// is done first or any email was left pending: the changes are then indexed again by the
+// resync of the next start.
+func indexChanges(ctx context.Context, config *IndexerConfig, pending map[string]bool) {
+	var keys []string
+	var changed []string
+	for path, exists := range pending {
+		relPath, err := filepath.Rel(config.Dir, path)
+		if err != nil {
+			log.Printf("WARN: failed to index %v: %v", path, err)
+			continue
+		}
+		key := filepath.ToSlash(relPath)
+		if exists {
+			keys = append(keys, key)
+			changed = append(changed, path)
+		} else {
+			keys = append(keys, config.Manifest.removeFiles(key)...)
+		}
+	}
+	log.Printf("INFO: indexing %d changed paths", len(pending))
+
+	runPipeline(ctx, config, func(sources chan<- *emailSource) error {
+		for _, path := range changed {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				// removed after the event, the manifest keeps its previous emails until its Remove event
+				continue
+			}
+			if info.IsDir() {
+				// its files have their own events
+				continue
+			}
+			relPath, _ := filepath.Rel(config.Dir, path)
+			if reason := config.Filter.skipFile(filepath.ToSlash(relPath), info.Size()); reason != "" {
+				// its emails are deleted, if it was indexed before being filtered
+				config.progress.addSkippedFile(reason)
+				continue
+			}
+			if isFileChanged(config.Manifest, path, filepath.ToSlash(relPath)) {
+				sendFile(ctx, path, relPath, config.Maildir, config.Filter, config.progress, sources)
+			}
+			config.progress.addFile(info.Size())
+		}
+		return nil
+	})
+	if ctx.Err() != nil || config.progress.Snapshot().Pending > 0 {
+		return
+	}
+
+	stale := config.Manifest.commit(keys)
+	if err := deleteStaleEmails(ctx, stale, config.BulkUploadSize, config.Index); err != nil {
+		log.Printf("ERROR: failed to delete emails of removed files: %v", err)
+	}
+	if err := config.Manifest.Save(); err != nil {
+		log.Printf("ERROR: failed to save manifest: %v", err)
+	}
+}
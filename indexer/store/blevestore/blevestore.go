@@ -0,0 +1,417 @@
+package blevestore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/query"
+	index "github.com/blevesearch/bleve_index_api"
+
+	"github.com/amoralesc/email-indexer/indexer/email"
+	"github.com/amoralesc/email-indexer/indexer/store"
+)
+
+// errNoIndex is returned when the index is used before it's created.
+var errNoIndex = errors.New("emails index doesn't exist")
+
+// BleveStore is an EmailIndex embedded in the indexer, kept in a bleve index on disk.
+// It runs without zinc, but the index can only be opened by one process at a time,
+// so the emails are indexed (-i) and served (-s) by the same process.
+type BleveStore struct {
+	Path string // the directory of the index
+
+	mu    sync.RWMutex
+	index bleve.Index // nil if the index doesn't exist
+}
+
+// BleveStore is an EmailIndex.
+var _ store.EmailIndex = (*BleveStore)(nil)
+
+// OpenBleveStore returns the store with the index located at path. The index is
+// opened if it exists, or left to be created (see CreateIndex).
+func OpenBleveStore(path string) (*BleveStore, error) {
+	bleveStore := &BleveStore{Path: path}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return bleveStore, nil
+	}
+	bleveIndex, err := bleve.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	bleveStore.index = bleveIndex
+	return bleveStore, nil
+}
+
+// Close closes the index.
+func (bleveStore *BleveStore) Close() error {
+	bleveStore.mu.Lock()
+	defer bleveStore.mu.Unlock()
+	if bleveStore.index == nil {
+		return nil
+	}
+	err := bleveStore.index.Close()
+	bleveStore.index = nil
+	return err
+}
+
+// getIndex returns the index, or an error if it doesn't exist.
+func (bleveStore *BleveStore) getIndex() (bleve.Index, error) {
+	bleveStore.mu.RLock()
+	defer bleveStore.mu.RUnlock()
+	if bleveStore.index == nil {
+		return nil, errNoIndex
+	}
+	return bleveStore.index, nil
+}
+
+// CheckIndex returns true if the index exists.
+func (bleveStore *BleveStore) CheckIndex(ctx context.Context) (bool, error) {
+	bleveStore.mu.RLock()
+	defer bleveStore.mu.RUnlock()
+	return bleveStore.index != nil, nil
+}
+
+// CreateIndex creates the index, with the mapping of the zinc emails index.
+func (bleveStore *BleveStore) CreateIndex(ctx context.Context) error {
+	bleveStore.mu.Lock()
+	defer bleveStore.mu.Unlock()
+	if bleveStore.index != nil {
+		return fmt.Errorf("emails index already exists at %v", bleveStore.Path)
+	}
+	indexMapping, err := newIndexMapping()
+	if err != nil {
+		return err
+	}
+	bleveIndex, err := bleve.New(bleveStore.Path, indexMapping)
+	if err != nil {
+		return err
+	}
+	bleveStore.index = bleveIndex
+	return nil
+}
+
+// DeleteIndex closes the index and removes its directory.
+func (bleveStore *BleveStore) DeleteIndex(ctx context.Context) error {
+	if err := bleveStore.Close(); err != nil {
+		return err
+	}
+	return os.RemoveAll(bleveStore.Path)
+}
+
+// newDocument returns the document an email is indexed as: the fields of the email,
+// with its JSON in the source field. The empty fields are left out, so they are
+// missing (and sort last), as in zinc.
+func newDocument(emailWithId *store.EmailWithId) (map[string]interface{}, error) {
+	source, err := json.Marshal(emailWithId)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(source, &document); err != nil {
+		return nil, err
+	}
+	delete(document, "_id")
+	for field, value := range document {
+		if value == "" {
+			delete(document, field)
+		}
+	}
+	document[sourceField] = string(source)
+	return document, nil
+}
+
+// getEmail returns the email indexed with the given id, or nil if there isn't one.
+func getEmail(bleveIndex bleve.Index, id string) (*store.EmailWithId, error) {
+	document, err := bleveIndex.Document(id)
+	if err != nil || document == nil {
+		return nil, err
+	}
+	var source []byte
+	document.VisitFields(func(field index.Field) {
+		if field.Name() == sourceField {
+			source = field.Value()
+		}
+	})
+	return parseSource(id, source)
+}
+
+// parseSource parses the source field of an indexed email.
+func parseSource(id string, source []byte) (*store.EmailWithId, error) {
+	var emailWithId store.EmailWithId
+	if err := json.Unmarshal(source, &emailWithId); err != nil {
+		return nil, fmt.Errorf("error parsing email %v: %v", id, err)
+	}
+	emailWithId.Id = id
+	return &emailWithId, nil
+}
+
+// indexEmails indexes a list of emails in a single batch.
+func indexEmails(bleveIndex bleve.Index, emails []*store.EmailWithId) error {
+	batch := bleveIndex.NewBatch()
+	for _, emailWithId := range emails {
+		document, err := newDocument(emailWithId)
+		if err != nil {
+			return err
+		}
+		if err := batch.Index(emailWithId.Id, document); err != nil {
+			return err
+		}
+	}
+	return bleveIndex.Batch(batch)
+}
+
+// IndexEmails adds a list of emails to the index, replacing the ones with the same ids.
+// The user state of the emails that were already indexed is kept (see store.EmailWithId.KeepUserState).
+func (bleveStore *BleveStore) IndexEmails(ctx context.Context, emails []store.EmailWithId) error {
+	bleveIndex, err := bleveStore.getIndex()
+	if err != nil {
+		return err
+	}
+	records := make([]*store.EmailWithId, len(emails))
+	for i := range emails {
+		indexed, err := getEmail(bleveIndex, emails[i].Id)
+		if err != nil {
+			return err
+		}
+		if indexed != nil {
+			emails[i].KeepUserState(indexed.IsRead, indexed.IsStarred)
+		}
+		records[i] = &emails[i]
+	}
+	return indexEmails(bleveIndex, records)
+}
+
+// sortOrder translates the sort fields of the query settings (see store.ValidateSortField)
+// into a bleve sort order. As in zinc, the emails without a value sort last, and the
+// emails with more than one sort by the lowest (ascending) or highest (descending).
+func sortOrder(settings *store.QuerySettings) search.SortOrder {
+	var order search.SortOrder
+	for _, field := range strings.Split(settings.Sort, ",") {
+		sortField := &search.SortField{
+			Field:   strings.TrimPrefix(field, "-"),
+			Desc:    strings.HasPrefix(field, "-"),
+			Type:    search.SortFieldAsString,
+			Mode:    search.SortFieldMin,
+			Missing: search.SortFieldMissingLast,
+		}
+		if sortField.Desc {
+			sortField.Mode = search.SortFieldMax
+		}
+		if sortField.Field == "date" {
+			sortField.Type = search.SortFieldAsDate
+		}
+		order = append(order, sortField)
+	}
+	// ties are sorted by id, so the pages are stable
+	return append(order, &search.SortDocID{})
+}
+
+// sendQuery searches the index. It returns the emails that match the query, sorted and
+// paginated by the settings (if any), and only the starred ones if the settings say so.
+func (bleveStore *BleveStore) sendQuery(ctx context.Context, q query.Query, settings *store.QuerySettings) (*store.QueryResponse, error) {
+	bleveIndex, err := bleveStore.getIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	size, from := 1, 0
+	if settings != nil {
+		size, from = settings.Pagination.Size, settings.Pagination.Start
+		if settings.StarredOnly {
+			starred := bleve.NewBoolFieldQuery(true)
+			starred.SetField("isStarred")
+			q = bleve.NewConjunctionQuery(q, starred)
+		}
+	}
+	request := bleve.NewSearchRequestOptions(q, size, from, false)
+	request.Fields = []string{sourceField}
+	if settings != nil {
+		request.SortByCustom(sortOrder(settings))
+	}
+
+	result, err := bleveIndex.SearchInContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	emails := make([]store.EmailWithId, len(result.Hits))
+	for i, hit := range result.Hits {
+		source, _ := hit.Fields[sourceField].(string)
+		emailWithId, err := parseSource(hit.ID, []byte(source))
+		if err != nil {
+			return nil, err
+		}
+		emails[i] = *emailWithId
+	}
+
+	return &store.QueryResponse{
+		Total:  int(result.Total),
+		Took:   int(result.Took.Milliseconds()),
+		Emails: emails,
+	}, nil
+}
+
+// GetAllEmails returns all emails in the index (paginated).
+func (bleveStore *BleveStore) GetAllEmails(ctx context.Context, settings *store.QuerySettings) (*store.QueryResponse, error) {
+	return bleveStore.sendQuery(ctx, bleve.NewMatchAllQuery(), settings)
+}
+
+// termQuery returns a query for the emails whose field has the exact value.
+func termQuery(field string, value string) query.Query {
+	q := bleve.NewTermQuery(value)
+	q.SetField(field)
+	return q
+}
+
+// matchQuery returns a query for the emails whose text field has any of the words of value.
+func matchQuery(field string, value string) query.Query {
+	q := bleve.NewMatchQuery(value)
+	q.SetField(field)
+	return q
+}
+
+// GetEmailsBySearchQuery returns all emails that match the given search query (paginated),
+// with the semantics of zinc.GetEmailsBySearchQuery.
+func (bleveStore *BleveStore) GetEmailsBySearchQuery(ctx context.Context, searchQuery *store.SearchQuery, settings *store.QuerySettings) (*store.QueryResponse, error) {
+	q := bleve.NewBooleanQuery()
+	// addresses are indexed in lowercase
+	if searchQuery.From != "" {
+		q.AddMust(termQuery("from", strings.ToLower(searchQuery.From)))
+	}
+	for field, addresses := range map[string][]string{"to": searchQuery.To, "cc": searchQuery.Cc, "bcc": searchQuery.Bcc} {
+		for _, address := range addresses {
+			q.AddMust(termQuery(field, strings.ToLower(address)))
+		}
+	}
+	if searchQuery.Mailbox != "" {
+		q.AddMust(termQuery("mailbox", searchQuery.Mailbox))
+	}
+	if searchQuery.Folder != "" {
+		q.AddMust(termQuery("folder", searchQuery.Folder))
+	}
+	if searchQuery.SubjectIncludes != "" {
+		q.AddMust(matchQuery("subject", searchQuery.SubjectIncludes))
+	}
+	if searchQuery.BodyIncludes != "" {
+		q.AddMust(matchQuery("body", searchQuery.BodyIncludes))
+	}
+	if searchQuery.BodyExcludes != "" {
+		q.AddMustNot(matchQuery("body", searchQuery.BodyExcludes))
+	}
+	q.AddMust(dateRangeQuery(searchQuery.DateRange))
+
+	return bleveStore.sendQuery(ctx, q, settings)
+}
+
+// dateRangeQuery returns a query for the emails in the date range. The bounds are
+// clamped to the dates bleve can index.
+func dateRangeQuery(dateRange store.DateRange) query.Query {
+	inclusive := true
+	from := dateRange.From
+	if from.Before(query.MinRFC3339CompatibleTime) {
+		from = query.MinRFC3339CompatibleTime
+	}
+	to := dateRange.To
+	if to.After(query.MaxRFC3339CompatibleTime) {
+		to = query.MaxRFC3339CompatibleTime
+	}
+	q := bleve.NewDateRangeInclusiveQuery(from, to, &inclusive, &inclusive)
+	q.SetField("date")
+	return q
+}
+
+// GetEmailsByQueryString returns all emails that match the given query string (paginated).
+// The query string has the syntax of bleve, which is close to the one of zinc. For example:
+// "query string +other word +subject:test"
+func (bleveStore *BleveStore) GetEmailsByQueryString(ctx context.Context, queryString string, settings *store.QuerySettings) (*store.QueryResponse, error) {
+	return bleveStore.sendQuery(ctx, bleve.NewQueryStringQuery(queryString), settings)
+}
+
+// GetEmailById returns the email that has the given id.
+func (bleveStore *BleveStore) GetEmailById(ctx context.Context, id string) (*store.EmailWithId, error) {
+	bleveIndex, err := bleveStore.getIndex()
+	if err != nil {
+		return nil, err
+	}
+	emailWithId, err := getEmail(bleveIndex, id)
+	if err != nil {
+		return nil, err
+	}
+	if emailWithId == nil {
+		return nil, fmt.Errorf("%w: %v", store.ErrNotFound, id)
+	}
+	return emailWithId, nil
+}
+
+// GetEmailByMessageId returns the email that has the given message id.
+func (bleveStore *BleveStore) GetEmailByMessageId(ctx context.Context, messageId string) (*store.EmailWithId, error) {
+	queryResponse, err := bleveStore.sendQuery(ctx, termQuery("messageId", messageId), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(queryResponse.Emails) == 0 {
+		return nil, fmt.Errorf("message %w: %v", store.ErrNotFound, messageId)
+	}
+	return &queryResponse.Emails[0], nil
+}
+
+// UpdateEmail replaces the email that has the given id.
+func (bleveStore *BleveStore) UpdateEmail(ctx context.Context, id string, email *email.Email) (*store.EmailWithId, error) {
+	if _, err := bleveStore.GetEmailById(ctx, id); err != nil {
+		return nil, err
+	}
+	bleveIndex, err := bleveStore.getIndex()
+	if err != nil {
+		return nil, err
+	}
+	emailWithId := store.NewEmailWithId(id, email)
+	if err := indexEmails(bleveIndex, []*store.EmailWithId{emailWithId}); err != nil {
+		return nil, err
+	}
+	return emailWithId, nil
+}
+
+// UpdateEmails replaces a list of emails, by their ids. The emails that aren't
+// in the index are added, as zinc does.
+func (bleveStore *BleveStore) UpdateEmails(ctx context.Context, emails []*store.EmailWithId) ([]*store.EmailWithId, error) {
+	bleveIndex, err := bleveStore.getIndex()
+	if err != nil {
+		return nil, err
+	}
+	if err := indexEmails(bleveIndex, emails); err != nil {
+		return nil, err
+	}
+	return emails, nil
+}
+
+// DeleteEmail deletes the email that has the given id.
+func (bleveStore *BleveStore) DeleteEmail(ctx context.Context, id string) error {
+	if _, err := bleveStore.GetEmailById(ctx, id); err != nil {
+		return err
+	}
+	bleveIndex, err := bleveStore.getIndex()
+	if err != nil {
+		return err
+	}
+	return bleveIndex.Delete(id)
+}
+
+// DeleteEmails deletes a list of emails, by their ids. The ids that aren't in the index are ignored.
+func (bleveStore *BleveStore) DeleteEmails(ctx context.Context, ids []string) error {
+	bleveIndex, err := bleveStore.getIndex()
+	if err != nil {
+		return err
+	}
+	batch := bleveIndex.NewBatch()
+	for _, id := range ids {
+		batch.Delete(id)
+	}
+	return bleveIndex.Batch(batch)
+}
@@ -0,0 +1,68 @@
+package blevestore
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/amoralesc/email-indexer/indexer/email"
+	"github.com/amoralesc/email-indexer/indexer/store"
+	"github.com/amoralesc/email-indexer/indexer/store/storetest"
+)
+
+// newTestStore returns a store with the given emails, in an index of a temporary directory.
+func newTestStore(t *testing.T, emails []store.EmailWithId) store.EmailStore {
+	t.Helper()
+	bleveStore, err := OpenBleveStore(filepath.Join(t.TempDir(), "emails.bleve"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bleveStore.Close() })
+
+	ctx := context.Background()
+	if err := bleveStore.CreateIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := bleveStore.IndexEmails(ctx, emails); err != nil {
+		t.Fatal(err)
+	}
+	return bleveStore
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, newTestStore)
+}
+
+func TestGetEmailsByQueryString(t *testing.T) {
+	// the terms without a field search the text and address fields, not the paths nor the hashes
+	emails := storetest.Emails()
+	emails[0].SourcePath = "lay.mbox"
+	emails[0].Attachments = []email.Attachment{{Filename: "forecast 2001.xls", Hash: "3f2a9c"}}
+	emails[1].ToRecipients = []email.Address{{Name: "Alice Lay", Address: "alice@enron.com"}}
+	tests := []struct {
+		queryString string
+		want        []string
+	}{
+		{"lay.mbox", []string{}},
+		{"3f2a9c", []string{}},
+		{"inbox", []string{}},
+		{"forecast", []string{"e1"}},
+		{"lay", []string{"e2"}},
+		{"sourcePath:lay.mbox", []string{"e1"}},
+		{"attachments.hash:3f2a9c", []string{"e1"}},
+	}
+
+	bleveStore := newTestStore(t, emails)
+	for _, test := range tests {
+		t.Run(test.queryString, func(t *testing.T) {
+			resp, err := bleveStore.GetEmailsByQueryString(context.Background(), test.queryString, storetest.Settings(t, "date", 0, 0, false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := storetest.Ids(resp); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
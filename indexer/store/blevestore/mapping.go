@@ -0,0 +1,132 @@
+package blevestore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/v2/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/v2/mapping"
+
+	"github.com/amoralesc/email-indexer/indexer/zinc"
+)
+
+// addressFields are the keyword fields searched by the terms of a query string without
+// a field (the _all field), besides the text fields.
+var addressFields = map[string]bool{
+	"from":                  true,
+	"to":                    true,
+	"cc":                    true,
+	"bcc":                   true,
+	"sender.address":        true,
+	"toRecipients.address":  true,
+	"ccRecipients.address":  true,
+	"bccRecipients.address": true,
+}
+
+const (
+	// textAnalyzer splits the text fields into lowercase words, like the standard analyzer of zinc.
+	textAnalyzer = "text"
+	// sourceField keeps the JSON of the indexed email, which is returned by the searches.
+	sourceField = "_source"
+)
+
+// newIndexMapping translates the mapping of the zinc emails index (zinc.EmailsIndexMapping)
+// into a bleve mapping. The nested fields (like sender.name) are mapped in sub-documents,
+// and the sub-fields (like sender.name.keyword) as other fields of the same property.
+// The fields that aren't in the mapping (the headers) aren't indexed. As in zinc, the
+// terms of a query string without a field only search the text and address fields.
+func newIndexMapping() (*mapping.IndexMappingImpl, error) {
+	properties, err := zinc.EmailsIndexProperties()
+	if err != nil {
+		return nil, err
+	}
+
+	indexMapping := bleve.NewIndexMapping()
+	err = indexMapping.AddCustomAnalyzer(textAnalyzer, map[string]interface{}{
+		"type":          custom.Name,
+		"tokenizer":     unicode.Name,
+		"token_filters": []string{lowercase.Name},
+	})
+	if err != nil {
+		return nil, err
+	}
+	indexMapping.DefaultAnalyzer = textAnalyzer
+	indexMapping.IndexDynamic = false
+	indexMapping.StoreDynamic = false
+	indexMapping.DocValuesDynamic = false
+
+	emailMapping := bleve.NewDocumentStaticMapping()
+	for name, property := range properties {
+		fieldMapping, err := newFieldMapping(property)
+		if err != nil {
+			return nil, fmt.Errorf("field %v: %w", name, err)
+		}
+		fieldMapping.IncludeInAll = property.Index && (property.Type == "text" || addressFields[name])
+		fieldMappings := []*mapping.FieldMapping{fieldMapping}
+		for subName, subProperty := range property.Fields {
+			subMapping, err := newFieldMapping(subProperty)
+			if err != nil {
+				return nil, fmt.Errorf("field %v.%v: %w", name, subName, err)
+			}
+			subMapping.Name = name[strings.LastIndex(name, ".")+1:] + "." + subName
+			fieldMappings = append(fieldMappings, subMapping)
+		}
+
+		// sender.name is the field name of the sender document
+		documentMapping := emailMapping
+		path := strings.Split(name, ".")
+		for _, parent := range path[:len(path)-1] {
+			child, ok := documentMapping.Properties[parent]
+			if !ok {
+				child = bleve.NewDocumentStaticMapping()
+				documentMapping.AddSubDocumentMapping(parent, child)
+			}
+			documentMapping = child
+		}
+		documentMapping.AddFieldMappingsAt(path[len(path)-1], fieldMappings...)
+	}
+
+	// the searches return the emails as they were indexed
+	source := bleve.NewTextFieldMapping()
+	source.Analyzer = keyword.Name
+	source.Index = false
+	source.Store = true
+	source.IncludeInAll = false
+	source.DocValues = false
+	emailMapping.AddFieldMappingsAt(sourceField, source)
+
+	indexMapping.DefaultMapping = emailMapping
+	return indexMapping, nil
+}
+
+// newFieldMapping returns the bleve mapping of a field of the zinc mapping.
+// The fields aren't stored, since the emails are kept in the source field,
+// nor added to the _all field (see newIndexMapping).
+func newFieldMapping(property zinc.IndexProperty) (*mapping.FieldMapping, error) {
+	var fieldMapping *mapping.FieldMapping
+	switch property.Type {
+	case "keyword":
+		fieldMapping = bleve.NewKeywordFieldMapping()
+	case "text":
+		fieldMapping = bleve.NewTextFieldMapping()
+		fieldMapping.Analyzer = textAnalyzer
+	case "date":
+		fieldMapping = bleve.NewDateTimeFieldMapping()
+	case "numeric":
+		fieldMapping = bleve.NewNumericFieldMapping()
+	case "boolean":
+		fieldMapping = bleve.NewBooleanFieldMapping()
+	default:
+		return nil, fmt.Errorf("unsupported type %q", property.Type)
+	}
+	fieldMapping.Index = property.Index
+	fieldMapping.IncludeInAll = false
+	// doc values are needed to sort and to aggregate (facet) on the field
+	fieldMapping.DocValues = property.Sortable || property.Aggregatable
+	fieldMapping.Store = false
+	return fieldMapping, nil
+}
@@ -0,0 +1,123 @@
+package blevestore
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/mapping"
+
+	"github.com/amoralesc/email-indexer/indexer/zinc"
+)
+
+// fieldMappings returns the mappings of a field of the zinc mapping (like sender.name),
+// which is mapped in the sub-documents of its parents.
+func fieldMappings(indexMapping *mapping.IndexMappingImpl, name string) []*mapping.FieldMapping {
+	documentMapping := indexMapping.DefaultMapping
+	path := strings.Split(name, ".")
+	for _, parent := range path {
+		documentMapping = documentMapping.Properties[parent]
+		if documentMapping == nil {
+			return nil
+		}
+	}
+	return documentMapping.Fields
+}
+
+func TestNewIndexMapping(t *testing.T) {
+	indexMapping, err := newIndexMapping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := indexMapping.Validate(); err != nil {
+		t.Fatalf("invalid mapping: %v", err)
+	}
+	if indexMapping.IndexDynamic || indexMapping.StoreDynamic || indexMapping.DocValuesDynamic {
+		t.Error("the fields that aren't in the zinc mapping are indexed")
+	}
+
+	// every field of the zinc mapping is translated, with its sub-fields
+	properties, err := zinc.EmailsIndexProperties()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, property := range properties {
+		fields := fieldMappings(indexMapping, name)
+		if len(fields) != 1+len(property.Fields) {
+			t.Errorf("%v: got %d field mappings, want %d", name, len(fields), 1+len(property.Fields))
+			continue
+		}
+		field := fields[0]
+		if field.Index != property.Index || field.Store {
+			t.Errorf("%v: got index %v, store %v, want %v, false", name, field.Index, field.Store, property.Index)
+		}
+		if want := property.Sortable || property.Aggregatable; field.DocValues != want {
+			t.Errorf("%v: got doc values %v, want %v", name, field.DocValues, want)
+		}
+		for _, subField := range fields[1:] {
+			subName := strings.TrimPrefix(subField.Name, name[strings.LastIndex(name, ".")+1:]+".")
+			subProperty, ok := property.Fields[subName]
+			if !ok {
+				t.Errorf("%v: unexpected sub-field %v", name, subField.Name)
+				continue
+			}
+			if want := subProperty.Sortable || subProperty.Aggregatable; subField.DocValues != want {
+				t.Errorf("%v: got doc values %v, want %v", subField.Name, subField.DocValues, want)
+			}
+		}
+	}
+
+	// only the text and address fields are searched by the terms without a field
+	tests := []struct {
+		name         string
+		wantType     string
+		analyzer     string
+		includeInAll bool
+	}{
+		{"subject", "text", textAnalyzer, true},
+		{"body", "text", textAnalyzer, true},
+		{"from", "text", keyword.Name, true},
+		{"to", "text", keyword.Name, true},
+		{"sender.name", "text", textAnalyzer, true},
+		{"toRecipients.address", "text", keyword.Name, true},
+		{"attachments.filename", "text", textAnalyzer, true},
+		{"xFrom", "text", textAnalyzer, true},
+		{"messageId", "text", keyword.Name, false},
+		{"sourcePath", "text", keyword.Name, false},
+		{"sourceArchive", "text", keyword.Name, false},
+		{"maildirUniqueName", "text", keyword.Name, false},
+		{"attachments.hash", "text", keyword.Name, false},
+		{"dateSource", "text", keyword.Name, false},
+		{"mailbox", "text", keyword.Name, false},
+		{"date", "datetime", "", false},
+		{"attachments.size", "number", "", false},
+		{"isStarred", "boolean", "", false},
+	}
+	for _, test := range tests {
+		fields := fieldMappings(indexMapping, test.name)
+		if len(fields) == 0 {
+			t.Errorf("%v: not mapped", test.name)
+			continue
+		}
+		if fields[0].Type != test.wantType || fields[0].Analyzer != test.analyzer {
+			t.Errorf("%v: got type %v, analyzer %q, want %v, %q", test.name, fields[0].Type, fields[0].Analyzer, test.wantType, test.analyzer)
+		}
+		for _, field := range fields {
+			if want := test.includeInAll && field == fields[0]; field.IncludeInAll != want {
+				t.Errorf("%v: got %v in _all %v, want %v", test.name, field.Name, field.IncludeInAll, want)
+			}
+		}
+	}
+
+	// sender.name.keyword sorts the sender by name
+	fields := fieldMappings(indexMapping, "sender.name")
+	if len(fields) != 2 || fields[1].Name != "name.keyword" || fields[1].Analyzer != keyword.Name || !fields[1].DocValues {
+		t.Errorf("sender.name: got sub-fields %+v, want a sortable name.keyword", fields[1:])
+	}
+
+	// the emails are returned from the source field, which isn't searched
+	source := fieldMappings(indexMapping, sourceField)
+	if len(source) != 1 || !source[0].Store || source[0].Index || source[0].IncludeInAll {
+		t.Errorf("got source field %+v, want it stored only", source)
+	}
+}
@@ -0,0 +1,34 @@
+package elastic
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/amoralesc/email-indexer/indexer/store"
+)
+
+// DeleteEmail deletes an email from the cluster.
+func (elasticStore *ElasticStore) DeleteEmail(ctx context.Context, id string) error {
+	statusCode, body, err := elasticStore.send(ctx, "DELETE", elasticStore.documentPath("_doc", id)+refreshParam, nil, "")
+	if err != nil {
+		return err
+	}
+	if statusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %v", store.ErrNotFound, id)
+	}
+	if statusCode != http.StatusOK {
+		return &ResponseError{StatusCode: statusCode, Body: string(body)}
+	}
+	return nil
+}
+
+// DeleteEmails deletes a list of emails from the cluster with _bulk.
+// The ids that aren't in the index are ignored.
+func (elasticStore *ElasticStore) DeleteEmails(ctx context.Context, ids []string) error {
+	actions := make([]string, len(ids))
+	for i := range ids {
+		actions[i] = "delete"
+	}
+	return elasticStore.bulk(ctx, bulkPath+refreshParam, actions, ids, make([]interface{}, len(ids)), http.StatusNotFound)
+}
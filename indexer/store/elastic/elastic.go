@@ -0,0 +1,101 @@
+package elastic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/amoralesc/email-indexer/indexer/store"
+)
+
+// ElasticStore is an EmailIndex kept in an Elasticsearch (or OpenSearch) cluster, with
+// their native APIs: the emails are uploaded with _bulk, updated with _update, and the
+// index is created from an index template with the mapping of the zinc emails index.
+type ElasticStore struct {
+	Url      string       // the url of the cluster, like http://localhost:9200
+	User     string       // the user of the cluster, if it has basic auth
+	Password string       // the password of the user
+	Index    string       // the name of the emails index
+	Client   *http.Client // the client the requests are sent with
+}
+
+// ElasticStore is an EmailIndex.
+var _ store.EmailIndex = (*ElasticStore)(nil)
+
+// NewElasticStore returns a store with the emails index of the cluster at url.
+// Without a user, the requests are sent without auth.
+func NewElasticStore(url, user, password, index string) *ElasticStore {
+	return &ElasticStore{
+		Url:      url,
+		User:     user,
+		Password: password,
+		Index:    index,
+		Client:   http.DefaultClient,
+	}
+}
+
+// ResponseError is returned when the cluster responds to a request with an error.
+// Its status code tells whether the request may succeed if it's sent again.
+type ResponseError struct {
+	StatusCode int
+	Body       string
+}
+
+func (err *ResponseError) Error() string {
+	return fmt.Sprintf("elasticsearch responded with code %v: %v", err.StatusCode, err.Body)
+}
+
+// IsTemporary returns true if the cluster failed to handle the request (5xx) or
+// asked to slow down (429), rather than rejecting it.
+func (err *ResponseError) IsTemporary() bool {
+	return err.StatusCode >= 500 || err.StatusCode == http.StatusTooManyRequests
+}
+
+// send sends a request to the cluster. The body is sent as contentType, if it isn't nil.
+// It returns the status code and body of the response.
+func (elasticStore *ElasticStore) send(ctx context.Context, method string, path string, body []byte, contentType string) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	// create the request
+	req, err := http.NewRequestWithContext(ctx, method, elasticStore.Url+path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	if elasticStore.User != "" {
+		req.SetBasicAuth(elasticStore.User, elasticStore.Password)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	// send the request
+	resp, err := elasticStore.Client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, respBody, nil
+}
+
+// sendJSON sends a request with a JSON body to the cluster, and returns the body of the
+// response. A response with a status code other than 2xx is returned as a ResponseError.
+func (elasticStore *ElasticStore) sendJSON(ctx context.Context, method string, path string, body []byte) ([]byte, error) {
+	statusCode, respBody, err := elasticStore.send(ctx, method, path, body, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	if statusCode < 200 || statusCode > 299 {
+		return nil, &ResponseError{StatusCode: statusCode, Body: string(respBody)}
+	}
+	return respBody, nil
+}
@@ -0,0 +1,435 @@
+package elastic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/amoralesc/email-indexer/indexer/email"
+	"github.com/amoralesc/email-indexer/indexer/store"
+)
+
+// request is a request received by the test cluster.
+type request struct {
+	Method      string
+	Path        string // with the query
+	ContentType string
+	Body        []byte
+}
+
+// testCluster is a stand-in for an Elasticsearch cluster. It records the requests it
+// receives, and responds with the status code and body returned by respond.
+type testCluster struct {
+	mu       sync.Mutex
+	requests []request
+}
+
+// newTestStore returns a store with the emails index of a test cluster.
+func newTestStore(t *testing.T, respond func(req request) (int, string)) (*ElasticStore, *testCluster) {
+	t.Helper()
+	cluster := &testCluster{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req := request{Method: r.Method, Path: r.URL.RequestURI(), ContentType: r.Header.Get("Content-Type"), Body: body}
+		cluster.mu.Lock()
+		cluster.requests = append(cluster.requests, req)
+		cluster.mu.Unlock()
+
+		statusCode, respBody := respond(req)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		io.WriteString(w, respBody)
+	}))
+	t.Cleanup(server.Close)
+
+	elasticStore := NewElasticStore(server.URL, "", "", "emails")
+	elasticStore.Client = server.Client()
+	return elasticStore, cluster
+}
+
+// paths returns the method and path of the requests received, in order.
+func (cluster *testCluster) paths() []string {
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	paths := []string{}
+	for _, req := range cluster.requests {
+		paths = append(paths, req.Method+" "+req.Path)
+	}
+	return paths
+}
+
+// last returns the last request received with the given method and path.
+func (cluster *testCluster) last(t *testing.T, method string, path string) request {
+	t.Helper()
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	for i := len(cluster.requests) - 1; i >= 0; i-- {
+		if cluster.requests[i].Method == method && cluster.requests[i].Path == path {
+			return cluster.requests[i]
+		}
+	}
+	t.Fatalf("no request %v %v", method, path)
+	return request{}
+}
+
+// ndjson parses the lines of an NDJSON body.
+func ndjson(t *testing.T, body []byte) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("invalid line %s: %v", scanner.Bytes(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// get returns the value at a path of keys of a decoded JSON object, or nil.
+func get(value interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+func TestCreateIndex(t *testing.T) {
+	elasticStore, cluster := newTestStore(t, func(request) (int, string) {
+		return http.StatusOK, `{"acknowledged": true}`
+	})
+	if err := elasticStore.CreateIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := cluster.paths(), []string{"PUT /_index_template/emails", "PUT /emails"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got requests %v, want %v", got, want)
+	}
+	var template map[string]interface{}
+	if err := json.Unmarshal(cluster.last(t, "PUT", "/_index_template/emails").Body, &template); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		keys []string
+		want interface{}
+	}{
+		{[]string{"index_patterns"}, []interface{}{"emails"}},
+		{[]string{"template", "mappings", "dynamic"}, false},
+		{[]string{"template", "mappings", "properties", "subject", "type"}, "text"},
+		{[]string{"template", "mappings", "properties", "from", "type"}, "keyword"},
+		{[]string{"template", "mappings", "properties", "from", "doc_values"}, true},
+		{[]string{"template", "mappings", "properties", "date", "type"}, "date"},
+		{[]string{"template", "mappings", "properties", "date", "format"}, "strict_date_optional_time"},
+		// aggregatable but not sortable
+		{[]string{"template", "mappings", "properties", "dateSource", "doc_values"}, true},
+		{[]string{"template", "mappings", "properties", "xFolder", "doc_values"}, true},
+		{[]string{"template", "mappings", "properties", "attachments", "properties", "contentType", "doc_values"}, true},
+		// neither sortable nor aggregatable
+		{[]string{"template", "mappings", "properties", "headers"}, nil},
+		{[]string{"template", "mappings", "properties", "sender", "properties", "name", "type"}, "text"},
+		{[]string{"template", "mappings", "properties", "sender", "properties", "name", "fields", "keyword", "type"}, "keyword"},
+	}
+	for _, test := range tests {
+		if got := get(template, test.keys...); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.keys, got, test.want)
+		}
+	}
+}
+
+func TestIndexEmails(t *testing.T) {
+	elasticStore, cluster := newTestStore(t, func(req request) (int, string) {
+		switch req.Path {
+		case "/emails/_search":
+			// e1 and e3 were indexed before, and read and starred since
+			return http.StatusOK, `{"took": 1, "hits": {"total": {"value": 2}, "hits": [
+				{"_id": "e1", "_source": {"isRead": true, "isStarred": true}},
+				{"_id": "e3", "_source": {"isRead": true, "isStarred": true}}
+			]}}`
+		case "/_bulk":
+			return http.StatusOK, `{"errors": false, "items": [
+				{"index": {"_id": "e1", "status": 200}}, {"index": {"_id": "e2", "status": 201}}
+			]}`
+		}
+		return http.StatusNotFound, `{}`
+	})
+
+	emails := []store.EmailWithId{
+		{Id: "e1", Subject: "Budget meeting", From: "alice@enron.com"},
+		{Id: "e2", Subject: "Lunch plans", From: "bob@enron.com", IsRead: true},
+		// a Maildir file whose flags were changed by a mail client (unread, not flagged)
+		{Id: "e3", Subject: "Quarterly report", MaildirUniqueName: "1001.M1P1.host"},
+	}
+	if err := elasticStore.IndexEmails(context.Background(), emails); err != nil {
+		t.Fatal(err)
+	}
+
+	// the user states are queried by ids
+	var search map[string]interface{}
+	if err := json.Unmarshal(cluster.last(t, "POST", "/emails/_search").Body, &search); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := get(search, "query", "ids", "values"), []interface{}{"e1", "e2", "e3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got ids %v, want %v", got, want)
+	}
+	if got, want := get(search, "_source"), []interface{}{"isRead", "isStarred"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got _source %v, want %v", got, want)
+	}
+	if got := get(search, "size"); got != 3.0 {
+		t.Errorf("got size %v, want 3", got)
+	}
+
+	// the emails are uploaded without waiting for a refresh
+	bulk := cluster.last(t, "POST", "/_bulk")
+	if bulk.ContentType != "application/x-ndjson" {
+		t.Errorf("got content type %q, want application/x-ndjson", bulk.ContentType)
+	}
+	lines := ndjson(t, bulk.Body)
+	if len(lines) != 6 {
+		t.Fatalf("got %d lines, want 6: %s", len(lines), bulk.Body)
+	}
+	for i, id := range []string{"e1", "e2", "e3"} {
+		action, source := lines[2*i], lines[2*i+1]
+		if got, want := action, map[string]interface{}{"index": map[string]interface{}{"_index": "emails", "_id": id}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got action %v, want %v", got, want)
+		}
+		if _, ok := source["_id"]; ok {
+			t.Errorf("source of %v has _id, which is metadata", id)
+		}
+		if source["subject"] != emails[i].Subject {
+			t.Errorf("got subject %v, want %v", source["subject"], emails[i].Subject)
+		}
+	}
+	// the state of e1 is kept, and e2 (new) keeps its own
+	if lines[1]["isRead"] != true || lines[1]["isStarred"] != true {
+		t.Errorf("the user state of e1 wasn't kept: %v", lines[1])
+	}
+	if lines[3]["isRead"] != true || lines[3]["isStarred"] != false {
+		t.Errorf("the user state of e2 changed: %v", lines[3])
+	}
+	// the flags of the Maildir file of e3 win over its indexed state
+	if lines[5]["isRead"] != false || lines[5]["isStarred"] != false {
+		t.Errorf("the flags of e3 were overwritten: %v", lines[5])
+	}
+}
+
+func TestBulkItemErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		items         string
+		wantStatus    int // 0 if no error is expected
+		wantTemporary bool
+	}{
+		{"no errors", `{"index": {"_id": "e1", "status": 200}}, {"index": {"_id": "e2", "status": 201}}`, 0, false},
+		{"rejected", `{"index": {"_id": "e1", "status": 200}}, {"index": {"_id": "e2", "status": 400, "error": {"type": "mapper_parsing_exception"}}}`, http.StatusBadRequest, false},
+		{"rejected and throttled", `{"index": {"_id": "e1", "status": 400, "error": {}}}, {"index": {"_id": "e2", "status": 429, "error": {}}}`, http.StatusTooManyRequests, true},
+		{"server error", `{"index": {"_id": "e1", "status": 503, "error": {}}}`, http.StatusServiceUnavailable, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			elasticStore, _ := newTestStore(t, func(req request) (int, string) {
+				if req.Path == "/emails/_search" {
+					return http.StatusOK, `{"hits": {"hits": []}}`
+				}
+				return http.StatusOK, `{"errors": true, "items": [` + test.items + `]}`
+			})
+			err := elasticStore.IndexEmails(context.Background(), []store.EmailWithId{{Id: "e1"}, {Id: "e2"}})
+
+			if test.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				return
+			}
+			var responseErr *ResponseError
+			if !errors.As(err, &responseErr) {
+				t.Fatalf("got error %v, want a ResponseError", err)
+			}
+			if responseErr.StatusCode != test.wantStatus || responseErr.IsTemporary() != test.wantTemporary {
+				t.Errorf("got status %v (temporary %v), want %v (temporary %v)",
+					responseErr.StatusCode, responseErr.IsTemporary(), test.wantStatus, test.wantTemporary)
+			}
+		})
+	}
+}
+
+func TestDeleteEmails(t *testing.T) {
+	elasticStore, cluster := newTestStore(t, func(request) (int, string) {
+		// the ids that aren't indexed are ignored
+		return http.StatusOK, `{"errors": true, "items": [
+			{"delete": {"_id": "e1", "status": 200}}, {"delete": {"_id": "missing", "status": 404}}
+		]}`
+	})
+	if err := elasticStore.DeleteEmails(context.Background(), []string{"e1", "missing"}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := ndjson(t, cluster.last(t, "POST", "/_bulk?refresh=wait_for").Body)
+	want := []map[string]interface{}{
+		{"delete": map[string]interface{}{"_index": "emails", "_id": "e1"}},
+		{"delete": map[string]interface{}{"_index": "emails", "_id": "missing"}},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("got lines %v, want %v", lines, want)
+	}
+}
+
+func TestUpdateEmail(t *testing.T) {
+	elasticStore, cluster := newTestStore(t, func(req request) (int, string) {
+		if req.Path == "/emails/_update/missing?refresh=wait_for" {
+			return http.StatusNotFound, `{"error": {"type": "document_missing_exception"}}`
+		}
+		return http.StatusOK, `{"result": "updated"}`
+	})
+
+	emailWithId, err := elasticStore.UpdateEmail(context.Background(), "e1", &email.Email{Subject: "Dinner plans", IsStarred: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if emailWithId.Id != "e1" || emailWithId.Subject != "Dinner plans" {
+		t.Errorf("got %v, want e1 with the new subject", emailWithId)
+	}
+
+	var update map[string]interface{}
+	if err := json.Unmarshal(cluster.last(t, "POST", "/emails/_update/e1?refresh=wait_for").Body, &update); err != nil {
+		t.Fatal(err)
+	}
+	if len(update) != 1 {
+		t.Errorf("got update %v, want only doc", update)
+	}
+	if get(update, "doc", "subject") != "Dinner plans" || get(update, "doc", "isStarred") != true {
+		t.Errorf("got doc %v, want the email", get(update, "doc"))
+	}
+	if _, ok := get(update, "doc").(map[string]interface{})["_id"]; ok {
+		t.Errorf("doc has _id, which is metadata")
+	}
+
+	_, err = elasticStore.UpdateEmail(context.Background(), "missing", &email.Email{})
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("got error %v, want ErrNotFound", err)
+	}
+}
+
+func TestUpdateEmails(t *testing.T) {
+	elasticStore, cluster := newTestStore(t, func(request) (int, string) {
+		return http.StatusOK, `{"errors": false, "items": [{"update": {"_id": "e1", "status": 200}}]}`
+	})
+	emails := []*store.EmailWithId{{Id: "e1", Subject: "Dinner plans"}}
+	if _, err := elasticStore.UpdateEmails(context.Background(), emails); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := ndjson(t, cluster.last(t, "POST", "/_bulk?refresh=wait_for").Body)
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	if got, want := lines[0], map[string]interface{}{"update": map[string]interface{}{"_index": "emails", "_id": "e1"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got action %v, want %v", got, want)
+	}
+	if get(lines[1], "doc", "subject") != "Dinner plans" || get(lines[1], "doc_as_upsert") != true {
+		t.Errorf("got update %v, want the email as upsert", lines[1])
+	}
+}
+
+func TestGetEmailById(t *testing.T) {
+	elasticStore, _ := newTestStore(t, func(req request) (int, string) {
+		if req.Path == "/emails/_doc/e1" {
+			return http.StatusOK, `{"_id": "e1", "found": true, "_source": {"subject": "Budget meeting"}}`
+		}
+		return http.StatusNotFound, `{"_id": "missing", "found": false}`
+	})
+
+	emailWithId, err := elasticStore.GetEmailById(context.Background(), "e1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if emailWithId.Id != "e1" || emailWithId.Subject != "Budget meeting" {
+		t.Errorf("got %v, want e1", emailWithId)
+	}
+	if _, err := elasticStore.GetEmailById(context.Background(), "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("got error %v, want ErrNotFound", err)
+	}
+}
+
+func TestGetEmailsBySearchQuery(t *testing.T) {
+	elasticStore, cluster := newTestStore(t, func(request) (int, string) {
+		return http.StatusOK, `{"took": 3, "hits": {"total": {"value": 12}, "hits": [{"_id": "e1", "_source": {"subject": "Budget meeting"}}]}}`
+	})
+	settings, err := store.NewQuerySettings("-to", 10, 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	searchQuery := &store.SearchQuery{From: "Alice@Enron.com", SubjectIncludes: "budget", BodyExcludes: "lunch"}
+	resp, err := elasticStore.GetEmailsBySearchQuery(context.Background(), searchQuery, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 12 || resp.Took != 3 || len(resp.Emails) != 1 || resp.Emails[0].Id != "e1" {
+		t.Errorf("got response %+v", resp)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(cluster.last(t, "POST", "/emails/_search").Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		keys []string
+		want interface{}
+	}{
+		{[]string{"from"}, 10.0},
+		{[]string{"size"}, 5.0},
+		{[]string{"track_total_hits"}, true},
+		{[]string{"query", "bool", "must"}, []interface{}{
+			map[string]interface{}{"term": map[string]interface{}{"from": "alice@enron.com"}},
+			map[string]interface{}{"match": map[string]interface{}{"subject": "budget"}},
+		}},
+		{[]string{"query", "bool", "must_not"}, []interface{}{
+			map[string]interface{}{"match": map[string]interface{}{"body": "lunch"}},
+		}},
+		{[]string{"sort"}, []interface{}{
+			map[string]interface{}{"to": map[string]interface{}{"order": "desc", "mode": "max", "missing": "_last"}},
+			map[string]interface{}{"date": map[string]interface{}{"order": "desc", "mode": "max", "missing": "_last"}},
+			map[string]interface{}{"messageId": map[string]interface{}{"order": "asc", "mode": "min", "missing": "_last"}},
+		}},
+	}
+	for _, test := range tests {
+		if got := get(body, test.keys...); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.keys, got, test.want)
+		}
+	}
+	// the starred filter is added to the date range
+	filters, _ := get(body, "query", "bool", "filter").([]interface{})
+	if len(filters) != 2 || !reflect.DeepEqual(filters[1], map[string]interface{}{"term": map[string]interface{}{"isStarred": true}}) {
+		t.Errorf("got filters %v, want the date range and the starred filter", filters)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	var user, password string
+	var ok bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok = r.BasicAuth()
+	}))
+	defer server.Close()
+
+	elasticStore := NewElasticStore(server.URL, "elastic", "secret", "emails")
+	if _, err := elasticStore.CheckIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !ok || user != "elastic" || password != "secret" {
+		t.Errorf("got basic auth %q %q (%v), want elastic secret", user, password, ok)
+	}
+}
@@ -0,0 +1,143 @@
+package elastic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/amoralesc/email-indexer/indexer/zinc"
+)
+
+const indexTemplatePath = "/_index_template/"
+
+// CheckIndex checks if the emails index exists in the cluster.
+func (elasticStore *ElasticStore) CheckIndex(ctx context.Context) (bool, error) {
+	statusCode, body, err := elasticStore.send(ctx, "HEAD", "/"+url.PathEscape(elasticStore.Index), nil, "")
+	if err != nil {
+		return false, err
+	}
+	switch statusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, &ResponseError{StatusCode: statusCode, Body: string(body)}
+	}
+}
+
+// CreateIndex creates the emails index in the cluster. Its mapping is saved in an index
+// template first, so the index gets it even if it's created again by another client.
+func (elasticStore *ElasticStore) CreateIndex(ctx context.Context) error {
+	mapping, err := indexMapping()
+	if err != nil {
+		return err
+	}
+	template, err := json.Marshal(map[string]interface{}{
+		"index_patterns": []string{elasticStore.Index},
+		"template": map[string]interface{}{
+			"mappings": mapping,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := elasticStore.sendJSON(ctx, "PUT", indexTemplatePath+url.PathEscape(elasticStore.Index), template); err != nil {
+		return fmt.Errorf("error creating index template: %w", err)
+	}
+	_, err = elasticStore.sendJSON(ctx, "PUT", "/"+url.PathEscape(elasticStore.Index), []byte("{}"))
+	return err
+}
+
+// DeleteIndex deletes the emails index (and its template) from the cluster.
+func (elasticStore *ElasticStore) DeleteIndex(ctx context.Context) error {
+	if _, err := elasticStore.sendJSON(ctx, "DELETE", "/"+url.PathEscape(elasticStore.Index), nil); err != nil {
+		return err
+	}
+	statusCode, body, err := elasticStore.send(ctx, "DELETE", indexTemplatePath+url.PathEscape(elasticStore.Index), nil, "")
+	if err != nil {
+		return err
+	}
+	if statusCode != http.StatusOK && statusCode != http.StatusNotFound {
+		return &ResponseError{StatusCode: statusCode, Body: string(body)}
+	}
+	return nil
+}
+
+// indexMapping translates the mapping of the zinc emails index (zinc.EmailsIndexMapping)
+// into an Elasticsearch mapping. The nested fields (like sender.name) are mapped as
+// properties of objects. The fields that aren't in the mapping (the headers) are kept
+// in the source of the emails, but aren't indexed.
+func indexMapping() (map[string]interface{}, error) {
+	properties, err := zinc.EmailsIndexProperties()
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := map[string]interface{}{
+		"dynamic":    false,
+		"properties": map[string]interface{}{},
+	}
+	for name, property := range properties {
+		field, err := newField(property)
+		if err != nil {
+			return nil, fmt.Errorf("field %v: %w", name, err)
+		}
+
+		// sender.name is the name property of the sender object
+		object := mapping
+		path := strings.Split(name, ".")
+		for _, parent := range path[:len(path)-1] {
+			objectProperties := object["properties"].(map[string]interface{})
+			child, ok := objectProperties[parent].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{"properties": map[string]interface{}{}}
+				objectProperties[parent] = child
+			}
+			object = child
+		}
+		object["properties"].(map[string]interface{})[path[len(path)-1]] = field
+	}
+	return mapping, nil
+}
+
+// newField returns the Elasticsearch mapping of a field of the zinc mapping. The fields
+// zinc sorts or aggregates on keep doc values, which both need.
+func newField(property zinc.IndexProperty) (map[string]interface{}, error) {
+	field := map[string]interface{}{"index": property.Index}
+	docValues := property.Sortable || property.Aggregatable
+	switch property.Type {
+	case "keyword", "boolean":
+		field["type"] = property.Type
+		field["doc_values"] = docValues
+	case "text":
+		field["type"] = "text"
+	case "date":
+		field["type"] = "date"
+		field["format"] = "strict_date_optional_time"
+		field["doc_values"] = docValues
+	case "numeric":
+		// the numeric fields of the emails are integers
+		field["type"] = "long"
+		field["doc_values"] = docValues
+	default:
+		return nil, fmt.Errorf("unsupported type %q", property.Type)
+	}
+
+	if len(property.Fields) > 0 {
+		fields := map[string]interface{}{}
+		for name, subProperty := range property.Fields {
+			subField, err := newField(subProperty)
+			if err != nil {
+				return nil, fmt.Errorf("sub-field %v: %w", name, err)
+			}
+			fields[name] = subField
+		}
+		field["fields"] = fields
+	}
+	return field, nil
+}
@@ -0,0 +1,198 @@
+package elastic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/amoralesc/email-indexer/indexer/store"
+)
+
+// query is a query (or part of one) of the Elasticsearch query DSL.
+type query map[string]interface{}
+
+// parseQueryResponse parses the body of a response of _search into a store.QueryResponse.
+func parseQueryResponse(body []byte) (*store.QueryResponse, error) {
+	var resp struct {
+		Took int `json:"took"`
+		Hits struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Id     string            `json:"_id"`
+				Source store.EmailWithId `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	emails := make([]store.EmailWithId, len(resp.Hits.Hits))
+	for i, hit := range resp.Hits.Hits {
+		emails[i] = hit.Source
+		emails[i].Id = hit.Id
+	}
+
+	return &store.QueryResponse{
+		Total:  resp.Hits.Total.Value,
+		Took:   resp.Took,
+		Emails: emails,
+	}, nil
+}
+
+// sendQuery sends a search request to the emails index. It returns the emails that match.
+func (elasticStore *ElasticStore) sendQuery(ctx context.Context, body []byte) (*store.QueryResponse, error) {
+	respBody, err := elasticStore.sendJSON(ctx, "POST", "/"+url.PathEscape(elasticStore.Index)+"/_search", body)
+	if err != nil {
+		return nil, err
+	}
+	queryResponse, err := parseQueryResponse(respBody)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing response: %v", err)
+	}
+	return queryResponse, nil
+}
+
+// parseQuerySortSettings translates the sort fields of the query settings (see
+// store.ValidateSortField) into sort clauses. As in zinc, the emails without a value sort
+// last, and the emails with more than one sort by the lowest (ascending) or highest (descending).
+func parseQuerySortSettings(settings *store.QuerySettings) []query {
+	sortFields := strings.Split(settings.Sort, ",")
+	clauses := make([]query, len(sortFields))
+	for i, field := range sortFields {
+		order, mode := "asc", "min"
+		if strings.HasPrefix(field, "-") {
+			order, mode = "desc", "max"
+		}
+		clauses[i] = query{strings.TrimPrefix(field, "-"): query{"order": order, "mode": mode, "missing": "_last"}}
+	}
+	return clauses
+}
+
+// search sends a bool query (with the starred filter of the settings, if set) sorted
+// and paginated by the settings. The total of emails that match is always counted.
+func (elasticStore *ElasticStore) search(ctx context.Context, boolQuery query, settings *store.QuerySettings) (*store.QueryResponse, error) {
+	if settings.StarredOnly {
+		filters, _ := boolQuery["filter"].([]query)
+		boolQuery["filter"] = append(filters, query{"term": query{"isStarred": true}})
+	}
+	body, err := json.Marshal(query{
+		"query":            query{"bool": boolQuery},
+		"sort":             parseQuerySortSettings(settings),
+		"from":             settings.Pagination.Start,
+		"size":             settings.Pagination.Size,
+		"track_total_hits": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return elasticStore.sendQuery(ctx, body)
+}
+
+// GetAllEmails returns all emails from the cluster (paginated).
+func (elasticStore *ElasticStore) GetAllEmails(ctx context.Context, settings *store.QuerySettings) (*store.QueryResponse, error) {
+	return elasticStore.search(ctx, query{"must": []query{{"match_all": query{}}}}, settings)
+}
+
+// GetEmailsBySearchQuery returns all emails that match the given search query (paginated),
+// with the same query as zinc.GetEmailsBySearchQuery.
+func (elasticStore *ElasticStore) GetEmailsBySearchQuery(ctx context.Context, searchQuery *store.SearchQuery, settings *store.QuerySettings) (*store.QueryResponse, error) {
+	// parse the must parameters
+	must := []query{}
+	// addresses are indexed in lowercase
+	if searchQuery.From != "" {
+		must = append(must, query{"term": query{"from": strings.ToLower(searchQuery.From)}})
+	}
+	for field, addresses := range map[string][]string{"to": searchQuery.To, "cc": searchQuery.Cc, "bcc": searchQuery.Bcc} {
+		for _, address := range addresses {
+			must = append(must, query{"term": query{field: strings.ToLower(address)}})
+		}
+	}
+	if searchQuery.Mailbox != "" {
+		must = append(must, query{"term": query{"mailbox": searchQuery.Mailbox}})
+	}
+	if searchQuery.Folder != "" {
+		must = append(must, query{"term": query{"folder": searchQuery.Folder}})
+	}
+	if searchQuery.SubjectIncludes != "" {
+		must = append(must, query{"match": query{"subject": searchQuery.SubjectIncludes}})
+	}
+	if searchQuery.BodyIncludes != "" {
+		must = append(must, query{"match": query{"body": searchQuery.BodyIncludes}})
+	}
+	// parse the must_not parameters
+	mustNot := []query{}
+	if searchQuery.BodyExcludes != "" {
+		mustNot = append(mustNot, query{"match": query{"body": searchQuery.BodyExcludes}})
+	}
+	// parse the filter parameters
+	dateRange := query{"gte": searchQuery.DateRange.From.Format(time.RFC3339), "format": "strict_date_optional_time"}
+	if !searchQuery.DateRange.To.IsZero() {
+		dateRange["lte"] = searchQuery.DateRange.To.Format(time.RFC3339)
+	}
+	filter := []query{{"range": query{"date": dateRange}}}
+
+	return elasticStore.search(ctx, query{"must": must, "must_not": mustNot, "filter": filter}, settings)
+}
+
+// GetEmailsByQueryString returns all emails that match the given query string (paginated).
+// A query string is a string composed of query language syntax. For example:
+// "query string +other word +subject:test"
+func (elasticStore *ElasticStore) GetEmailsByQueryString(ctx context.Context, queryString string, settings *store.QuerySettings) (*store.QueryResponse, error) {
+	return elasticStore.search(ctx, query{"must": []query{{"query_string": query{"query": queryString}}}}, settings)
+}
+
+// GetEmailByMessageId returns the email that has the given message id.
+func (elasticStore *ElasticStore) GetEmailByMessageId(ctx context.Context, messageId string) (*store.EmailWithId, error) {
+	body, err := json.Marshal(query{
+		"query": query{"term": query{"messageId": messageId}},
+		"size":  1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	queryResponse, err := elasticStore.sendQuery(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(queryResponse.Emails) == 0 {
+		return nil, fmt.Errorf("message %w: %v", store.ErrNotFound, messageId)
+	}
+	return &queryResponse.Emails[0], nil
+}
+
+// GetEmailById returns the email that has the given id.
+func (elasticStore *ElasticStore) GetEmailById(ctx context.Context, id string) (*store.EmailWithId, error) {
+	statusCode, body, err := elasticStore.send(ctx, "GET", elasticStore.documentPath("_doc", id), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if statusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %v", store.ErrNotFound, id)
+	}
+	if statusCode != http.StatusOK {
+		return nil, &ResponseError{StatusCode: statusCode, Body: string(body)}
+	}
+
+	var resp struct {
+		Id     string            `json:"_id"`
+		Source store.EmailWithId `json:"_source"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("error parsing response: %v", err)
+	}
+	emailWithId := resp.Source
+	emailWithId.Id = resp.Id
+	return &emailWithId, nil
+}
+
+// documentPath returns the path of an API of a document of the emails index, like _doc/id.
+func (elasticStore *ElasticStore) documentPath(api string, id string) string {
+	return "/" + url.PathEscape(elasticStore.Index) + "/" + api + "/" + url.PathEscape(id)
+}
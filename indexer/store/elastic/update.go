@@ -0,0 +1,59 @@
+package elastic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/amoralesc/email-indexer/indexer/email"
+	"github.com/amoralesc/email-indexer/indexer/store"
+)
+
+// UpdateEmail updates an email in the cluster with _update.
+func (elasticStore *ElasticStore) UpdateEmail(ctx context.Context, id string, email *email.Email) (*store.EmailWithId, error) {
+	emailWithId := store.NewEmailWithId(id, email)
+	document, err := source(emailWithId)
+	if err != nil {
+		return nil, err
+	}
+	jsonBytes, err := json.Marshal(query{"doc": document})
+	if err != nil {
+		return nil, err
+	}
+
+	statusCode, body, err := elasticStore.send(ctx, "POST", elasticStore.documentPath("_update", id)+refreshParam, jsonBytes, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	if statusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %v", store.ErrNotFound, id)
+	}
+	if statusCode != http.StatusOK {
+		return nil, &ResponseError{StatusCode: statusCode, Body: string(body)}
+	}
+
+	return emailWithId, nil
+}
+
+// UpdateEmails updates a list of emails in the cluster with _bulk. The emails that
+// aren't in the index are added, as zinc does.
+func (elasticStore *ElasticStore) UpdateEmails(ctx context.Context, emails []*store.EmailWithId) ([]*store.EmailWithId, error) {
+	actions := make([]string, len(emails))
+	ids := make([]string, len(emails))
+	documents := make([]interface{}, len(emails))
+	for i, emailWithId := range emails {
+		document, err := source(emailWithId)
+		if err != nil {
+			return nil, err
+		}
+		actions[i] = "update"
+		ids[i] = emailWithId.Id
+		documents[i] = query{"doc": document, "doc_as_upsert": true}
+	}
+
+	if err := elasticStore.bulk(ctx, bulkPath+refreshParam, actions, ids, documents, 0); err != nil {
+		return nil, err
+	}
+	return emails, nil
+}
@@ -0,0 +1,152 @@
+package elastic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/amoralesc/email-indexer/indexer/store"
+)
+
+const bulkPath = "/_bulk"
+
+// refreshParam makes a request wait until its changes are visible to the searches.
+// The changes made through the REST API use it, but not the uploads of the indexer.
+const refreshParam = "?refresh=wait_for"
+
+// bulkAction is the action line of a request to _bulk.
+type bulkAction map[string]struct {
+	Index string `json:"_index"`
+	Id    string `json:"_id"`
+}
+
+// bulkResponse is the response of _bulk. Every item has the result of an action.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Id     string          `json:"_id"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// source returns the JSON an email is indexed as, without its id (it's metadata).
+func source(emailWithId *store.EmailWithId) (map[string]interface{}, error) {
+	jsonBytes, err := json.Marshal(emailWithId)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(jsonBytes, &document); err != nil {
+		return nil, err
+	}
+	delete(document, "_id")
+	return document, nil
+}
+
+// bulk sends a list of actions to _bulk (at path, with its params), each followed by its
+// document (if not nil). It fails if any action fails, except with the status code ignoredStatus.
+// The status code of the error is the one of the first action that failed, unless an
+// action was rejected with 429 (so the request is retried).
+func (elasticStore *ElasticStore) bulk(ctx context.Context, path string, actions []string, ids []string, documents []interface{}, ignoredStatus int) error {
+	// encode one action (and document) per line (ndjson)
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for i, action := range actions {
+		line := bulkAction{action: {Index: elasticStore.Index, Id: ids[i]}}
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+		if documents[i] != nil {
+			if err := encoder.Encode(documents[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	statusCode, body, err := elasticStore.send(ctx, "POST", path, buf.Bytes(), "application/x-ndjson")
+	if err != nil {
+		return err
+	}
+	if statusCode != http.StatusOK {
+		return &ResponseError{StatusCode: statusCode, Body: string(body)}
+	}
+
+	var resp bulkResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("error parsing response: %v", err)
+	}
+	if !resp.Errors {
+		return nil
+	}
+
+	var failed *ResponseError
+	for _, item := range resp.Items {
+		for _, result := range item {
+			if result.Status < 300 || result.Status == ignoredStatus {
+				continue
+			}
+			if failed == nil {
+				failed = &ResponseError{StatusCode: result.Status, Body: fmt.Sprintf("item %v: %s", result.Id, result.Error)}
+			} else if result.Status == http.StatusTooManyRequests {
+				failed.StatusCode = result.Status
+			}
+		}
+	}
+	if failed != nil {
+		return failed
+	}
+	return nil
+}
+
+// userStates returns the user state (isRead, isStarred) of the indexed emails with the
+// given ids. Emails that aren't indexed are missing from the returned map.
+func (elasticStore *ElasticStore) userStates(ctx context.Context, ids []string) (map[string]store.EmailWithId, error) {
+	body, err := json.Marshal(query{
+		"query":   query{"ids": query{"values": ids}},
+		"_source": []string{"isRead", "isStarred"},
+		"size":    len(ids),
+	})
+	if err != nil {
+		return nil, err
+	}
+	queryResponse, err := elasticStore.sendQuery(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]store.EmailWithId, len(queryResponse.Emails))
+	for _, emailWithId := range queryResponse.Emails {
+		states[emailWithId.Id] = emailWithId
+	}
+	return states, nil
+}
+
+// IndexEmails uploads a list of emails to the cluster with _bulk. The user state of the
+// emails that were already indexed is kept (see store.EmailWithId.KeepUserState).
+func (elasticStore *ElasticStore) IndexEmails(ctx context.Context, emails []store.EmailWithId) error {
+	ids := make([]string, len(emails))
+	for i := range emails {
+		ids[i] = emails[i].Id
+	}
+	states, err := elasticStore.userStates(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	actions := make([]string, len(emails))
+	documents := make([]interface{}, len(emails))
+	for i := range emails {
+		if state, ok := states[emails[i].Id]; ok {
+			emails[i].KeepUserState(state.IsRead, state.IsStarred)
+		}
+		actions[i] = "index"
+		documents[i], err = source(&emails[i])
+		if err != nil {
+			return err
+		}
+	}
+	return elasticStore.bulk(ctx, bulkPath, actions, ids, documents, 0)
+}
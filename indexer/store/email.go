@@ -0,0 +1,104 @@
+package store
+
+import (
+	"time"
+
+	"github.com/amoralesc/email-indexer/indexer/email"
+)
+
+// EmailWithId is an email as it is stored, with its id.
+type EmailWithId struct {
+	Id                string              `json:"_id"`
+	MessageId         string              `json:"messageId"`
+	Date              time.Time           `json:"date"`
+	DateSource        string              `json:"dateSource"`
+	From              string              `json:"from"`
+	To                []string            `json:"to"`
+	Cc                []string            `json:"cc"`
+	Bcc               []string            `json:"bcc"`
+	Sender            email.Address       `json:"sender"`
+	ToRecipients      []email.Address     `json:"toRecipients"`
+	CcRecipients      []email.Address     `json:"ccRecipients"`
+	BccRecipients     []email.Address     `json:"bccRecipients"`
+	Subject           string              `json:"subject"`
+	Body              string              `json:"body"`
+	Attachments       []email.Attachment  `json:"attachments"`
+	Headers           map[string][]string `json:"headers"`
+	XFrom             string              `json:"xFrom"`
+	XTo               string              `json:"xTo"`
+	XCc               string              `json:"xCc"`
+	XBcc              string              `json:"xBcc"`
+	XFolder           string              `json:"xFolder"`
+	XOrigin           string              `json:"xOrigin"`
+	XFileName         string              `json:"xFileName"`
+	SourcePath        string              `json:"sourcePath"`
+	SourceArchive     string              `json:"sourceArchive"`
+	SourceOffset      int64               `json:"sourceOffset"`
+	Mailbox           string              `json:"mailbox"`
+	Folder            string              `json:"folder"`
+	MaildirUniqueName string              `json:"maildirUniqueName"`
+	IsRead            bool                `json:"isRead"`
+	IsStarred         bool                `json:"isStarred"`
+	IsReplied         bool                `json:"isReplied"`
+	IsTrashed         bool                `json:"isTrashed"`
+	IsDraft           bool                `json:"isDraft"`
+}
+
+// NewEmailWithId creates an EmailWithId from an email and its id.
+func NewEmailWithId(id string, emailObj *email.Email) *EmailWithId {
+	return &EmailWithId{
+		Id:                id,
+		MessageId:         emailObj.MessageId,
+		Date:              emailObj.Date,
+		DateSource:        emailObj.DateSource,
+		From:              emailObj.From,
+		To:                emailObj.To,
+		Cc:                emailObj.Cc,
+		Bcc:               emailObj.Bcc,
+		Sender:            emailObj.Sender,
+		ToRecipients:      emailObj.ToRecipients,
+		CcRecipients:      emailObj.CcRecipients,
+		BccRecipients:     emailObj.BccRecipients,
+		Subject:           emailObj.Subject,
+		Body:              emailObj.Body,
+		Attachments:       emailObj.Attachments,
+		Headers:           emailObj.Headers,
+		XFrom:             emailObj.XFrom,
+		XTo:               emailObj.XTo,
+		XCc:               emailObj.XCc,
+		XBcc:              emailObj.XBcc,
+		XFolder:           emailObj.XFolder,
+		XOrigin:           emailObj.XOrigin,
+		XFileName:         emailObj.XFileName,
+		SourcePath:        emailObj.SourcePath,
+		SourceArchive:     emailObj.SourceArchive,
+		SourceOffset:      emailObj.SourceOffset,
+		Mailbox:           emailObj.Mailbox,
+		Folder:            emailObj.Folder,
+		MaildirUniqueName: emailObj.MaildirUniqueName,
+		IsRead:            emailObj.IsRead,
+		IsStarred:         emailObj.IsStarred,
+		IsReplied:         emailObj.IsReplied,
+		IsTrashed:         emailObj.IsTrashed,
+		IsDraft:           emailObj.IsDraft,
+	}
+}
+
+// KeepUserState sets the user state (isRead, isStarred) of an email being indexed again to
+// the state it has in the index, so reindexing doesn't reset it. The emails of Maildir files
+// keep the state of their file flags instead (see email.SetMaildirSource), since a mail client
+// changes them by renaming the file.
+func (emailWithId *EmailWithId) KeepUserState(isRead bool, isStarred bool) {
+	if emailWithId.MaildirUniqueName != "" {
+		return
+	}
+	emailWithId.IsRead = isRead
+	emailWithId.IsStarred = isStarred
+}
+
+// QueryResponse is the response of a store to a query.
+type QueryResponse struct {
+	Total  int           `json:"total"`  // Total number of emails that match the query (not the number of emails returned)
+	Took   int           `json:"took"`   // Time it took to execute the query
+	Emails []EmailWithId `json:"emails"` // Emails that match the query (paginated)
+}
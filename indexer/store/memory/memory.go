@@ -0,0 +1,217 @@
+package memory
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/amoralesc/email-indexer/indexer/email"
+	"github.com/amoralesc/email-indexer/indexer/store"
+)
+
+// loadMaxLineSize is the size of the longest line (email) LoadExport can read.
+const loadMaxLineSize = 64 * 1024 * 1024
+
+// MemoryStore is an EmailStore that keeps the emails in memory, with the same search
+// semantics as zinc. It's meant to run the REST API without zinc, for tests and demos.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	emails map[string]*store.EmailWithId // id -> email
+}
+
+// MemoryStore is an EmailStore.
+var _ store.EmailStore = (*MemoryStore)(nil)
+
+// NewMemoryStore returns an empty memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{emails: map[string]*store.EmailWithId{}}
+}
+
+// AddEmails adds a list of emails to the store, replacing the ones with the same ids.
+func (memoryStore *MemoryStore) AddEmails(emails []store.EmailWithId) {
+	memoryStore.mu.Lock()
+	defer memoryStore.mu.Unlock()
+	for i := range emails {
+		emailWithId := emails[i]
+		memoryStore.emails[emailWithId.Id] = &emailWithId
+	}
+}
+
+// LoadExport adds the emails of an NDJSON file exported by the indexer (compressed with
+// gzip or not) to the store, with their document ids. It returns the number of emails added.
+func (memoryStore *MemoryStore) LoadExport(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var r io.Reader = reader
+	if magic, _ := reader.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return 0, err
+		}
+		defer gzipReader.Close()
+		r = gzipReader
+	}
+
+	var emails []store.EmailWithId
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), loadMaxLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var emailObj email.Email
+		if err := json.Unmarshal(scanner.Bytes(), &emailObj); err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+		emails = append(emails, *store.NewEmailWithId(emailObj.DocumentId(), &emailObj))
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	memoryStore.AddEmails(emails)
+	return len(emails), nil
+}
+
+// query returns the emails that match, sorted and paginated by the settings.
+func (memoryStore *MemoryStore) query(match func(emailWithId *store.EmailWithId) bool, settings *store.QuerySettings) *store.QueryResponse {
+	start := time.Now()
+	memoryStore.mu.RLock()
+	var matches []store.EmailWithId
+	for _, emailWithId := range memoryStore.emails {
+		if settings.StarredOnly && !emailWithId.IsStarred {
+			continue
+		}
+		if match(emailWithId) {
+			matches = append(matches, *emailWithId)
+		}
+	}
+	memoryStore.mu.RUnlock()
+
+	sortEmails(matches, settings.Sort)
+	total := len(matches)
+	from, to := settings.Pagination.Start, settings.Pagination.Start+settings.Pagination.Size
+	if from > total {
+		from = total
+	}
+	if to > total {
+		to = total
+	}
+
+	return &store.QueryResponse{
+		Total:  total,
+		Took:   int(time.Since(start).Milliseconds()),
+		Emails: matches[from:to],
+	}
+}
+
+// GetAllEmails returns all emails in the store (paginated).
+func (memoryStore *MemoryStore) GetAllEmails(ctx context.Context, settings *store.QuerySettings) (*store.QueryResponse, error) {
+	return memoryStore.query(func(*store.EmailWithId) bool { return true }, settings), nil
+}
+
+// GetEmailsBySearchQuery returns all emails that match the given search query (paginated).
+func (memoryStore *MemoryStore) GetEmailsBySearchQuery(ctx context.Context, searchQuery *store.SearchQuery, settings *store.QuerySettings) (*store.QueryResponse, error) {
+	return memoryStore.query(func(emailWithId *store.EmailWithId) bool {
+		return matchesSearchQuery(emailWithId, searchQuery)
+	}, settings), nil
+}
+
+// GetEmailsByQueryString returns all emails that match the given query string (paginated).
+// See parseQueryString for the syntax supported.
+func (memoryStore *MemoryStore) GetEmailsByQueryString(ctx context.Context, queryString string, settings *store.QuerySettings) (*store.QueryResponse, error) {
+	clauses := parseQueryString(queryString)
+	return memoryStore.query(func(emailWithId *store.EmailWithId) bool {
+		return matchesQueryString(emailWithId, clauses)
+	}, settings), nil
+}
+
+// GetEmailById returns the email that has the given id.
+func (memoryStore *MemoryStore) GetEmailById(ctx context.Context, id string) (*store.EmailWithId, error) {
+	memoryStore.mu.RLock()
+	defer memoryStore.mu.RUnlock()
+	emailWithId, ok := memoryStore.emails[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", store.ErrNotFound, id)
+	}
+	found := *emailWithId
+	return &found, nil
+}
+
+// GetEmailByMessageId returns the email that has the given message id. If more than one
+// email has it, the first one by id is returned.
+func (memoryStore *MemoryStore) GetEmailByMessageId(ctx context.Context, messageId string) (*store.EmailWithId, error) {
+	memoryStore.mu.RLock()
+	defer memoryStore.mu.RUnlock()
+	var found *store.EmailWithId
+	for _, emailWithId := range memoryStore.emails {
+		if emailWithId.MessageId == messageId && (found == nil || emailWithId.Id < found.Id) {
+			found = emailWithId
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("message %w: %v", store.ErrNotFound, messageId)
+	}
+	result := *found
+	return &result, nil
+}
+
+// UpdateEmail replaces the email that has the given id.
+func (memoryStore *MemoryStore) UpdateEmail(ctx context.Context, id string, email *email.Email) (*store.EmailWithId, error) {
+	memoryStore.mu.Lock()
+	defer memoryStore.mu.Unlock()
+	if _, ok := memoryStore.emails[id]; !ok {
+		return nil, fmt.Errorf("%w: %v", store.ErrNotFound, id)
+	}
+	emailWithId := store.NewEmailWithId(id, email)
+	memoryStore.emails[id] = emailWithId
+	updated := *emailWithId
+	return &updated, nil
+}
+
+// UpdateEmails replaces a list of emails, by their ids. The emails that aren't
+// in the store are added, as zinc does.
+func (memoryStore *MemoryStore) UpdateEmails(ctx context.Context, emails []*store.EmailWithId) ([]*store.EmailWithId, error) {
+	memoryStore.mu.Lock()
+	defer memoryStore.mu.Unlock()
+	for _, emailWithId := range emails {
+		updated := *emailWithId
+		memoryStore.emails[updated.Id] = &updated
+	}
+	return emails, nil
+}
+
+// DeleteEmail deletes the email that has the given id.
+func (memoryStore *MemoryStore) DeleteEmail(ctx context.Context, id string) error {
+	memoryStore.mu.Lock()
+	defer memoryStore.mu.Unlock()
+	if _, ok := memoryStore.emails[id]; !ok {
+		return fmt.Errorf("%w: %v", store.ErrNotFound, id)
+	}
+	delete(memoryStore.emails, id)
+	return nil
+}
+
+// DeleteEmails deletes a list of emails, by their ids. The ids that aren't in the store are ignored.
+func (memoryStore *MemoryStore) DeleteEmails(ctx context.Context, ids []string) error {
+	memoryStore.mu.Lock()
+	defer memoryStore.mu.Unlock()
+	for _, id := range ids {
+		delete(memoryStore.emails, id)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package memory
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/amoralesc/email-indexer/indexer/store"
+	"github.com/amoralesc/email-indexer/indexer/store/storetest"
+)
+
+// newTestStore returns a memory store with the given emails.
+func newTestStore(t *testing.T, emails []store.EmailWithId) store.EmailStore {
+	memoryStore := NewMemoryStore()
+	memoryStore.AddEmails(emails)
+	return memoryStore
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, newTestStore)
+}
+
+func TestGetEmailsByQueryString(t *testing.T) {
+	tests := []struct {
+		queryString string
+		want        []string
+	}{
+		{`"quarter next"`, []string{"e1"}}, // all the words, in any order
+		{`"next report"`, []string{}},
+		{"from:*@enron.com -mailbox:alice", []string{"e2"}},
+	}
+
+	memoryStore := newTestStore(t, storetest.Emails())
+	for _, test := range tests {
+		t.Run(test.queryString, func(t *testing.T) {
+			resp, err := memoryStore.GetEmailsByQueryString(context.Background(), test.queryString, storetest.Settings(t, "date", 0, 0, false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := storetest.Ids(resp); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
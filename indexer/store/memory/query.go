@@ -0,0 +1,309 @@
+package memory
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/amoralesc/email-indexer/indexer/store"
+)
+
+// textFields are the fields matched by their tokens (see tokenize). The others are keywords,
+// matched as a whole. These are the fields a query string searches when it doesn't name one.
+var textFields = map[string]func(emailWithId *store.EmailWithId) []string{
+	"subject": func(emailWithId *store.EmailWithId) []string { return []string{emailWithId.Subject} },
+	"body":    func(emailWithId *store.EmailWithId) []string { return []string{emailWithId.Body} },
+}
+
+var keywordFields = map[string]func(emailWithId *store.EmailWithId) []string{
+	"messageId": func(emailWithId *store.EmailWithId) []string { return []string{emailWithId.MessageId} },
+	"from":      func(emailWithId *store.EmailWithId) []string { return []string{emailWithId.From} },
+	"to":        func(emailWithId *store.EmailWithId) []string { return emailWithId.To },
+	"cc":        func(emailWithId *store.EmailWithId) []string { return emailWithId.Cc },
+	"bcc":       func(emailWithId *store.EmailWithId) []string { return emailWithId.Bcc },
+	"mailbox":   func(emailWithId *store.EmailWithId) []string { return []string{emailWithId.Mailbox} },
+	"folder":    func(emailWithId *store.EmailWithId) []string { return []string{emailWithId.Folder} },
+}
+
+// tokenize splits a text into its lowercase words, like the standard analyzer of zinc.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// matchText returns true if any of the words of value is in text, like a zinc match query.
+func matchText(text string, value string) bool {
+	words := map[string]bool{}
+	for _, word := range tokenize(text) {
+		words[word] = true
+	}
+	for _, word := range tokenize(value) {
+		if words[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns true if values has value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesSearchQuery returns true if an email matches all the fields of a search query,
+// with the semantics of zinc.GetEmailsBySearchQuery: addresses, mailbox and folder are
+// exact terms, the subject and body are matched by words, and the date range is inclusive.
+func matchesSearchQuery(emailWithId *store.EmailWithId, searchQuery *store.SearchQuery) bool {
+	// addresses are indexed in lowercase
+	if searchQuery.From != "" && emailWithId.From != strings.ToLower(searchQuery.From) {
+		return false
+	}
+	for _, addresses := range []struct {
+		query []string
+		email []string
+	}{
+		{searchQuery.To, emailWithId.To},
+		{searchQuery.Cc, emailWithId.Cc},
+		{searchQuery.Bcc, emailWithId.Bcc},
+	} {
+		for _, address := range addresses.query {
+			if !contains(addresses.email, strings.ToLower(address)) {
+				return false
+			}
+		}
+	}
+	if searchQuery.Mailbox != "" && emailWithId.Mailbox != searchQuery.Mailbox {
+		return false
+	}
+	if searchQuery.Folder != "" && emailWithId.Folder != searchQuery.Folder {
+		return false
+	}
+	if searchQuery.SubjectIncludes != "" && !matchText(emailWithId.Subject, searchQuery.SubjectIncludes) {
+		return false
+	}
+	if searchQuery.BodyIncludes != "" && !matchText(emailWithId.Body, searchQuery.BodyIncludes) {
+		return false
+	}
+	if searchQuery.BodyExcludes != "" && matchText(emailWithId.Body, searchQuery.BodyExcludes) {
+		return false
+	}
+	if emailWithId.Date.Before(searchQuery.DateRange.From) {
+		return false
+	}
+	if !searchQuery.DateRange.To.IsZero() && emailWithId.Date.After(searchQuery.DateRange.To) {
+		return false
+	}
+	return true
+}
+
+// queryClause is a term of a query string.
+type queryClause struct {
+	field   string // empty to search the default fields
+	value   string
+	must    bool // +term
+	mustNot bool // -term
+}
+
+// parseQueryString parses the subset of the query string syntax of zinc supported by
+// the memory store: terms (or "quoted phrases") separated by spaces, optionally prefixed
+// by a field (field:term) and by + (must match) or - (must not match). Terms may have
+// * and ? wildcards. An email matches if it matches all the + terms, none of the - terms,
+// and (if there are no + terms) at least one of the others.
+func parseQueryString(queryString string) []queryClause {
+	var clauses []queryClause
+	for _, term := range splitQueryString(queryString) {
+		clause := queryClause{}
+		switch {
+		case strings.HasPrefix(term, "+"):
+			clause.must = true
+			term = term[1:]
+		case strings.HasPrefix(term, "-"):
+			clause.mustNot = true
+			term = term[1:]
+		}
+		if field, value, ok := strings.Cut(term, ":"); ok && isField(field) {
+			clause.field = field
+			term = value
+		}
+		clause.value = strings.Trim(term, `"`)
+		if clause.value != "" {
+			clauses = append(clauses, clause)
+		}
+	}
+	return clauses
+}
+
+// splitQueryString splits a query string by spaces, keeping quoted phrases together.
+func splitQueryString(queryString string) []string {
+	var terms []string
+	var term strings.Builder
+	quoted := false
+	for _, r := range queryString {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			term.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if term.Len() > 0 {
+				terms = append(terms, term.String())
+				term.Reset()
+			}
+		default:
+			term.WriteRune(r)
+		}
+	}
+	if term.Len() > 0 {
+		terms = append(terms, term.String())
+	}
+	return terms
+}
+
+// isField returns true if field is a field a query string can search.
+func isField(field string) bool {
+	_, text := textFields[field]
+	_, keyword := keywordFields[field]
+	return text || keyword
+}
+
+// matchesQueryString returns true if an email matches the clauses of a query string.
+func matchesQueryString(emailWithId *store.EmailWithId, clauses []queryClause) bool {
+	hasMust, matchedShould, hasShould := false, false, false
+	for _, clause := range clauses {
+		matched := matchesClause(emailWithId, clause)
+		switch {
+		case clause.must:
+			if !matched {
+				return false
+			}
+			hasMust = true
+		case clause.mustNot:
+			if matched {
+				return false
+			}
+		default:
+			hasShould = true
+			matchedShould = matchedShould || matched
+		}
+	}
+	return hasMust || matchedShould || !hasShould
+}
+
+// matchesClause returns true if an email matches a clause of a query string. Text fields
+// match if they have all the words of the value, and keyword fields if one of their
+// values is the value.
+func matchesClause(emailWithId *store.EmailWithId, clause queryClause) bool {
+	for field, values := range textFields {
+		if clause.field != "" && clause.field != field {
+			continue
+		}
+		for _, text := range values(emailWithId) {
+			if matchWords(text, clause.value) {
+				return true
+			}
+		}
+	}
+	for field, values := range keywordFields {
+		if clause.field != "" && clause.field != field {
+			continue
+		}
+		for _, value := range values(emailWithId) {
+			if matched, _ := path.Match(clause.value, value); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchWords returns true if text has all the words of value, which may have wildcards.
+func matchWords(text string, value string) bool {
+	words := tokenize(text)
+	for _, pattern := range strings.Fields(strings.ToLower(value)) {
+		found := false
+		for _, word := range words {
+			if matched, _ := path.Match(pattern, word); matched {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// sortEmails sorts emails by the sort fields of the query settings (see store.ValidateSortField).
+// As in zinc, the emails without a value sort last, and the emails with more than one
+// sort by the lowest (ascending) or highest (descending). Ties are sorted by id.
+func sortEmails(emails []store.EmailWithId, sortFields string) {
+	fields := strings.Split(sortFields, ",")
+	sort.SliceStable(emails, func(i, j int) bool {
+		for _, field := range fields {
+			descending := strings.HasPrefix(field, "-")
+			field = strings.TrimPrefix(field, "-")
+			if cmp := compareField(&emails[i], &emails[j], field, descending); cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return emails[i].Id < emails[j].Id
+	})
+}
+
+// compareField compares a sort field of two emails, in the order they are sorted.
+func compareField(a *store.EmailWithId, b *store.EmailWithId, field string, descending bool) int {
+	if field == "date" {
+		switch {
+		case a.Date.Equal(b.Date):
+			return 0
+		case a.Date.Before(b.Date) != descending:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	values, ok := keywordFields[field]
+	if !ok {
+		return 0
+	}
+	aValue, aOk := sortValue(values(a), descending)
+	bValue, bOk := sortValue(values(b), descending)
+	switch {
+	case !aOk && !bOk:
+		return 0
+	case !aOk:
+		return 1
+	case !bOk:
+		return -1
+	}
+	cmp := strings.Compare(aValue, bValue)
+	if descending {
+		cmp = -cmp
+	}
+	return cmp
+}
+
+// sortValue returns the value an email is sorted by, from the values of a field: the
+// lowest one when sorting in ascending order, and the highest one otherwise.
+func sortValue(values []string, descending bool) (string, bool) {
+	found := false
+	var result string
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		if !found || (value < result) != descending {
+			result = value
+			found = true
+		}
+	}
+	return result, found
+}
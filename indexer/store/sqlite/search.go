@@ -0,0 +1,335 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/amoralesc/email-indexer/indexer/store"
+)
+
+// where is the WHERE clause of a query, built from conditions and their arguments.
+type where struct {
+	conditions []string
+	args       []interface{}
+}
+
+// add adds a condition (with its arguments) to the clause.
+func (clause *where) add(condition string, args ...interface{}) {
+	clause.conditions = append(clause.conditions, condition)
+	clause.args = append(clause.args, args...)
+}
+
+// String returns the conditions joined with AND, or a condition that's always true.
+func (clause *where) String() string {
+	if len(clause.conditions) == 0 {
+		return "1"
+	}
+	return strings.Join(clause.conditions, " AND ")
+}
+
+// ftsCondition is the condition of the emails whose subject or body match an FTS5 query.
+const ftsCondition = `emails.rowid IN (SELECT rowid FROM emails_fts WHERE emails_fts MATCH ?)`
+
+// addressCondition is the condition of the emails that have an address in the to, cc or bcc field.
+const addressCondition = `EXISTS (SELECT 1 FROM email_addresses WHERE email_id = emails.id AND field = ? AND address = ?)`
+
+// tokenize splits a text into its words, like the FTS5 tokenizer of the emails.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// phrase returns the words as an FTS5 phrase, which matches them in sequence.
+func phrase(words []string) string {
+	return `"` + strings.ReplaceAll(strings.Join(words, " "), `"`, `""`) + `"`
+}
+
+// matchAny returns an FTS5 query for the emails whose column has any of the words of
+// value, like a zinc match query, or "" if value has no words.
+func matchAny(column string, value string) string {
+	words := tokenize(value)
+	if len(words) == 0 {
+		return ""
+	}
+	phrases := make([]string, len(words))
+	for i, word := range words {
+		phrases[i] = phrase([]string{word})
+	}
+	return fmt.Sprintf("%v : (%v)", column, strings.Join(phrases, " OR "))
+}
+
+// sortExpressions are the SQL expressions of the sort fields (see store.ValidateSortField),
+// by the order they are sorted in. They are NULL for the emails without a value. As in
+// zinc, the emails with more than one value sort by the lowest (ascending) or highest
+// (descending).
+var sortExpressions = map[string]func(descending bool) string{
+	"messageId": func(bool) string { return "NULLIF(emails.message_id, '')" },
+	"date":      func(bool) string { return "emails.date" },
+	"from":      func(bool) string { return "NULLIF(emails.from_address, '')" },
+	"to":        func(descending bool) string { return addressSortExpression("to", descending) },
+	"cc":        func(descending bool) string { return addressSortExpression("cc", descending) },
+	"bcc":       func(descending bool) string { return addressSortExpression("bcc", descending) },
+}
+
+// addressSortExpression returns the expression of the to, cc or bcc sort field.
+func addressSortExpression(field string, descending bool) string {
+	aggregate := "MIN"
+	if descending {
+		aggregate = "MAX"
+	}
+	return fmt.Sprintf("(SELECT %v(address) FROM email_addresses WHERE email_id = emails.id AND field = '%v')", aggregate, field)
+}
+
+// orderBy translates the sort fields of the query settings into an ORDER BY clause.
+// The emails without a value sort last, and ties are sorted by id.
+func orderBy(settings *store.QuerySettings) string {
+	var terms []string
+	for _, field := range strings.Split(settings.Sort, ",") {
+		descending := strings.HasPrefix(field, "-")
+		expression, ok := sortExpressions[strings.TrimPrefix(field, "-")]
+		if !ok {
+			continue
+		}
+		direction := "ASC"
+		if descending {
+			direction = "DESC"
+		}
+		terms = append(terms, fmt.Sprintf("%[1]v IS NULL, %[1]v %[2]v", expression(descending), direction))
+	}
+	return strings.Join(append(terms, "emails.id"), ", ")
+}
+
+// search returns the emails that match the WHERE clause (and the starred filter of
+// the settings, if set), sorted and paginated by the settings.
+func (sqliteStore *SQLiteStore) search(ctx context.Context, clause *where, settings *store.QuerySettings) (*store.QueryResponse, error) {
+	start := time.Now()
+	if settings.StarredOnly {
+		clause.add("emails.is_starred = 1")
+	}
+
+	var total int
+	err := sqliteStore.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM emails WHERE "+clause.String(), clause.args...).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT emails.id, emails.source FROM emails WHERE %v ORDER BY %v LIMIT ? OFFSET ?", clause, orderBy(settings))
+	args := append(clause.args, settings.Pagination.Size, settings.Pagination.Start)
+	rows, err := sqliteStore.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []store.EmailWithId{}
+	for rows.Next() {
+		var id, source string
+		if err := rows.Scan(&id, &source); err != nil {
+			return nil, err
+		}
+		emailWithId, err := parseSource(id, source)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, *emailWithId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &store.QueryResponse{
+		Total:  total,
+		Took:   took(start),
+		Emails: emails,
+	}, nil
+}
+
+// GetAllEmails returns all emails in the index (paginated).
+func (sqliteStore *SQLiteStore) GetAllEmails(ctx context.Context, settings *store.QuerySettings) (*store.QueryResponse, error) {
+	return sqliteStore.search(ctx, &where{}, settings)
+}
+
+// GetEmailsBySearchQuery returns all emails that match the given search query (paginated),
+// with the semantics of zinc.GetEmailsBySearchQuery.
+func (sqliteStore *SQLiteStore) GetEmailsBySearchQuery(ctx context.Context, searchQuery *store.SearchQuery, settings *store.QuerySettings) (*store.QueryResponse, error) {
+	clause := &where{}
+	// addresses are indexed in lowercase
+	if searchQuery.From != "" {
+		clause.add("emails.from_address = ?", strings.ToLower(searchQuery.From))
+	}
+	for field, addresses := range map[string][]string{"to": searchQuery.To, "cc": searchQuery.Cc, "bcc": searchQuery.Bcc} {
+		for _, address := range addresses {
+			clause.add(addressCondition, field, strings.ToLower(address))
+		}
+	}
+	if searchQuery.Mailbox != "" {
+		clause.add("emails.mailbox = ?", searchQuery.Mailbox)
+	}
+	if searchQuery.Folder != "" {
+		clause.add("emails.folder = ?", searchQuery.Folder)
+	}
+	// a text without words matches nothing
+	for column, value := range map[string]string{"subject": searchQuery.SubjectIncludes, "body": searchQuery.BodyIncludes} {
+		if value == "" {
+			continue
+		}
+		if match := matchAny(column, value); match != "" {
+			clause.add(ftsCondition, match)
+		} else {
+			clause.add("0")
+		}
+	}
+	if match := matchAny("body", searchQuery.BodyExcludes); match != "" {
+		clause.add("NOT "+ftsCondition, match)
+	}
+	clause.add("emails.date >= ?", searchQuery.DateRange.From.UnixMilli())
+	if !searchQuery.DateRange.To.IsZero() {
+		clause.add("emails.date <= ?", searchQuery.DateRange.To.UnixMilli())
+	}
+
+	return sqliteStore.search(ctx, clause, settings)
+}
+
+// GetEmailsByQueryString returns all emails that match the given query string (paginated).
+// See parseQueryString for the syntax supported.
+func (sqliteStore *SQLiteStore) GetEmailsByQueryString(ctx context.Context, queryString string, settings *store.QuerySettings) (*store.QueryResponse, error) {
+	return sqliteStore.search(ctx, parseQueryString(queryString), settings)
+}
+
+// parseQueryString translates the subset of the query string syntax of zinc supported
+// by the SQLite store into a WHERE clause: terms (or "quoted phrases") separated by
+// spaces, optionally prefixed by a field (field:term) and by + (must match) or - (must
+// not match). A term ending with * matches the words that start with it. The subject
+// and body match if they have the words of the term in sequence, and the other fields
+// (from, to, cc, bcc, mailbox, folder and messageId) if they are the term. A term without
+// a field searches the subject, body and addresses. An email matches if it matches all
+// the + terms, none of the - terms, and (if there are no + terms) at least one of the others.
+func parseQueryString(queryString string) *where {
+	clause := &where{}
+	should := &where{}
+	hasMust := false
+	for _, term := range splitQueryString(queryString) {
+		must, mustNot := strings.HasPrefix(term, "+"), strings.HasPrefix(term, "-")
+		if must || mustNot {
+			term = term[1:]
+		}
+		field := ""
+		if name, value, ok := strings.Cut(term, ":"); ok && isQueryField(name) {
+			field, term = name, value
+		}
+		term = strings.Trim(term, `"`)
+		if term == "" {
+			continue
+		}
+
+		condition, args := termCondition(field, term)
+		switch {
+		case must:
+			clause.add(condition, args...)
+			hasMust = true
+		case mustNot:
+			clause.add("NOT "+condition, args...)
+		default:
+			should.add(condition, args...)
+		}
+	}
+
+	if !hasMust && len(should.conditions) > 0 {
+		clause.add("("+strings.Join(should.conditions, " OR ")+")", should.args...)
+	}
+	return clause
+}
+
+// splitQueryString splits a query string by spaces, keeping quoted phrases together.
+func splitQueryString(queryString string) []string {
+	var terms []string
+	var term strings.Builder
+	quoted := false
+	for _, r := range queryString {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			term.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if term.Len() > 0 {
+				terms = append(terms, term.String())
+				term.Reset()
+			}
+		default:
+			term.WriteRune(r)
+		}
+	}
+	if term.Len() > 0 {
+		terms = append(terms, term.String())
+	}
+	return terms
+}
+
+// keywordColumns are the columns of the fields a query string matches exactly.
+var keywordColumns = map[string]string{
+	"messageId": "emails.message_id",
+	"from":      "emails.from_address",
+	"mailbox":   "emails.mailbox",
+	"folder":    "emails.folder",
+}
+
+// isQueryField returns true if field is a field a query string can search.
+func isQueryField(field string) bool {
+	switch field {
+	case "subject", "body", "to", "cc", "bcc":
+		return true
+	}
+	_, ok := keywordColumns[field]
+	return ok
+}
+
+// termCondition returns the condition (and its arguments) of the emails that match
+// a term of a query string in the given field (or the default fields, if empty).
+func termCondition(field string, term string) (string, []interface{}) {
+	// addresses are indexed in lowercase
+	address := strings.ToLower(term)
+	if field == "from" {
+		return "emails.from_address = ?", []interface{}{address}
+	}
+	if column, ok := keywordColumns[field]; ok {
+		return column + " = ?", []interface{}{term}
+	}
+	switch field {
+	case "to", "cc", "bcc":
+		return addressCondition, []interface{}{field, address}
+	}
+
+	// the text fields match the words of the term in sequence
+	var text string
+	if words := tokenize(term); len(words) > 0 {
+		text = phrase(words)
+		if strings.HasSuffix(term, "*") {
+			text += " *"
+		}
+		if field != "" {
+			text = field + " : " + text
+		}
+	}
+	if field != "" {
+		if text == "" {
+			return "0", nil
+		}
+		return ftsCondition, []interface{}{text}
+	}
+
+	// without a field, the addresses are searched too
+	conditions := []string{
+		"emails.from_address = ?",
+		"EXISTS (SELECT 1 FROM email_addresses WHERE email_id = emails.id AND address = ?)",
+	}
+	args := []interface{}{address, address}
+	if text != "" {
+		conditions = append(conditions, ftsCondition)
+		args = append(args, text)
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
@@ -0,0 +1,97 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/amoralesc/email-indexer/indexer/store"
+	"github.com/amoralesc/email-indexer/indexer/store/storetest"
+)
+
+// newTestStore returns a store with the given emails, in a database file of a temporary directory.
+func newTestStore(t *testing.T, emails []store.EmailWithId) store.EmailStore {
+	t.Helper()
+	sqliteStore, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "emails.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqliteStore.Close() })
+
+	ctx := context.Background()
+	if err := sqliteStore.CreateIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqliteStore.IndexEmails(ctx, emails); err != nil {
+		t.Fatal(err)
+	}
+	return sqliteStore
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, newTestStore)
+}
+
+func TestPhrase(t *testing.T) {
+	tests := []struct {
+		words []string
+		want  string
+	}{
+		{[]string{"budget"}, `"budget"`},
+		{[]string{"next", "quarter"}, `"next quarter"`},
+		{[]string{"AND"}, `"AND"`},
+		{[]string{`say "hi"`}, `"say ""hi"""`},
+	}
+
+	for _, test := range tests {
+		if got := phrase(test.words); got != test.want {
+			t.Errorf("phrase(%q): got %v, want %v", test.words, got, test.want)
+		}
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	got := orderBy(&store.QuerySettings{Sort: "-to,date"})
+	want := "(SELECT MAX(address) FROM email_addresses WHERE email_id = emails.id AND field = 'to') IS NULL, " +
+		"(SELECT MAX(address) FROM email_addresses WHERE email_id = emails.id AND field = 'to') DESC, " +
+		"emails.date IS NULL, emails.date ASC, emails.id"
+	if got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGetEmailsByQueryString(t *testing.T) {
+	tests := []struct {
+		queryString string
+		want        []string
+	}{
+		{"+budget lunch", []string{"e1", "e2", "e4"}}, // the other terms are ignored if there are + terms
+		{"-lunch", []string{"e1", "e3", "e4"}},
+		{"from:ALICE@enron.com", []string{"e1"}},
+		{"+to:Alice@Enron.com -from:bob@enron.com", []string{"e3"}},
+		{"cc:dan@enron.com bcc:erin@enron.com", []string{"e1", "e3"}},
+		{"ALICE@enron.com", []string{"e1", "e2", "e3"}},
+		{`"quarter next"`, []string{}}, // the words in sequence
+		{`subject:"budget meeting"`, []string{"e1"}},
+		{"+lunch +AT", []string{"e2"}}, // not an FTS5 operator
+		{"OR", []string{}},
+		{`subject:"`, []string{"e1", "e2", "e3", "e4"}},
+	}
+
+	sqliteStore := newTestStore(t, storetest.Emails())
+	for _, test := range tests {
+		t.Run(test.queryString, func(t *testing.T) {
+			resp, err := sqliteStore.GetEmailsByQueryString(context.Background(), test.queryString, storetest.Settings(t, "date", 0, 0, false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := storetest.Ids(resp); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+			if resp.Total != len(test.want) {
+				t.Errorf("got total %v, want %v", resp.Total, len(test.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/amoralesc/email-indexer/indexer/email"
+	"github.com/amoralesc/email-indexer/indexer/store"
+)
+
+// schema creates the tables of the emails index. The emails are kept as JSON in the
+// source column, with the fields they are searched and sorted by in their own columns:
+// the subject and body in an FTS5 table (with the same rowid as the email), and the
+// to, cc and bcc addresses in a table with an address per row.
+const schema = `
+CREATE TABLE emails (
+	id           TEXT PRIMARY KEY,
+	message_id   TEXT NOT NULL,
+	date         INTEGER NOT NULL, -- unix milliseconds
+	from_address TEXT NOT NULL,
+	mailbox      TEXT NOT NULL,
+	folder       TEXT NOT NULL,
+	is_read      INTEGER NOT NULL,
+	is_starred   INTEGER NOT NULL,
+	source       TEXT NOT NULL
+);
+CREATE INDEX emails_message_id ON emails (message_id);
+CREATE INDEX emails_date ON emails (date);
+CREATE INDEX emails_from_address ON emails (from_address);
+CREATE INDEX emails_mailbox_folder ON emails (mailbox, folder);
+CREATE TABLE email_addresses (
+	email_id TEXT NOT NULL,
+	field    TEXT NOT NULL, -- to, cc or bcc
+	address  TEXT NOT NULL,
+	PRIMARY KEY (email_id, field, address)
+) WITHOUT ROWID;
+CREATE INDEX email_addresses_address ON email_addresses (field, address);
+CREATE VIRTUAL TABLE emails_fts USING fts5 (subject, body, tokenize = 'unicode61 remove_diacritics 0');
+`
+
+// SQLiteStore is an EmailIndex kept in a single SQLite file, which is easy to back up
+// and ship. The subject and body are searched with FTS5, and the other fields with
+// indexed columns. The writes wait for each other, so the file can be shared by the
+// indexer and the server (even in different processes).
+type SQLiteStore struct {
+	Path string // the path of the database file
+
+	db *sql.DB
+}
+
+// SQLiteStore is an EmailIndex.
+var _ store.EmailIndex = (*SQLiteStore)(nil)
+
+// OpenSQLiteStore returns the store with the database file located at path, which is
+// created if it doesn't exist. The emails index is left to be created (see CreateIndex).
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	// wait for the locks of other writers, and take the write lock when a transaction
+	// begins, so two transactions never wait for each other
+	dsn := fmt.Sprintf("file:%v?_pragma=busy_timeout(30000)&_pragma=journal_mode(WAL)&_txlock=immediate", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{Path: path, db: db}, nil
+}
+
+// Close closes the database.
+func (sqliteStore *SQLiteStore) Close() error {
+	return sqliteStore.db.Close()
+}
+
+// CheckIndex returns true if the tables of the emails index exist.
+func (sqliteStore *SQLiteStore) CheckIndex(ctx context.Context) (bool, error) {
+	var count int
+	err := sqliteStore.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'emails'`).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// CreateIndex creates the tables of the emails index.
+func (sqliteStore *SQLiteStore) CreateIndex(ctx context.Context) error {
+	_, err := sqliteStore.db.ExecContext(ctx, schema)
+	return err
+}
+
+// DeleteIndex drops the tables of the emails index.
+func (sqliteStore *SQLiteStore) DeleteIndex(ctx context.Context) error {
+	_, err := sqliteStore.db.ExecContext(ctx, `
+		DROP TABLE IF EXISTS emails_fts;
+		DROP TABLE IF EXISTS email_addresses;
+		DROP TABLE IF EXISTS emails;
+	`)
+	return err
+}
+
+// putEmail adds an email to the index, replacing the one with the same id.
+func putEmail(ctx context.Context, tx *sql.Tx, emailWithId *store.EmailWithId) error {
+	if err := deleteEmail(ctx, tx, emailWithId.Id); err != nil {
+		return err
+	}
+
+	source, err := json.Marshal(emailWithId)
+	if err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO emails (id, message_id, date, from_address, mailbox, folder, is_read, is_starred, source)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		emailWithId.Id, emailWithId.MessageId, emailWithId.Date.UnixMilli(), emailWithId.From,
+		emailWithId.Mailbox, emailWithId.Folder, emailWithId.IsRead, emailWithId.IsStarred, string(source))
+	if err != nil {
+		return err
+	}
+	rowid, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO emails_fts (rowid, subject, body) VALUES (?, ?, ?)`, rowid, emailWithId.Subject, emailWithId.Body)
+	if err != nil {
+		return err
+	}
+
+	for field, addresses := range map[string][]string{"to": emailWithId.To, "cc": emailWithId.Cc, "bcc": emailWithId.Bcc} {
+		for _, address := range addresses {
+			_, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO email_addresses (email_id, field, address) VALUES (?, ?, ?)`, emailWithId.Id, field, address)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteEmail deletes the email that has the given id from the index, if it's there.
+func deleteEmail(ctx context.Context, tx *sql.Tx, id string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM emails_fts WHERE rowid IN (SELECT rowid FROM emails WHERE id = ?)`, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM email_addresses WHERE email_id = ?`, id); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM emails WHERE id = ?`, id)
+	return err
+}
+
+// inTransaction calls operation in a transaction, which is committed if it succeeds.
+func (sqliteStore *SQLiteStore) inTransaction(ctx context.Context, operation func(tx *sql.Tx) error) error {
+	tx, err := sqliteStore.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := operation(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// IndexEmails adds a list of emails to the index in a single transaction, replacing the
+// ones with the same ids. The user state of the emails that were already indexed is kept
+// (see store.EmailWithId.KeepUserState).
+func (sqliteStore *SQLiteStore) IndexEmails(ctx context.Context, emails []store.EmailWithId) error {
+	return sqliteStore.inTransaction(ctx, func(tx *sql.Tx) error {
+		for i := range emails {
+			var isRead, isStarred bool
+			err := tx.QueryRowContext(ctx, `SELECT is_read, is_starred FROM emails WHERE id = ?`, emails[i].Id).Scan(&isRead, &isStarred)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			if err == nil {
+				emails[i].KeepUserState(isRead, isStarred)
+			}
+			if err := putEmail(ctx, tx, &emails[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// parseSource parses the source column of an indexed email.
+func parseSource(id string, source string) (*store.EmailWithId, error) {
+	var emailWithId store.EmailWithId
+	if err := json.Unmarshal([]byte(source), &emailWithId); err != nil {
+		return nil, fmt.Errorf("error parsing email %v: %v", id, err)
+	}
+	emailWithId.Id = id
+	return &emailWithId, nil
+}
+
+// GetEmailById returns the email that has the given id.
+func (sqliteStore *SQLiteStore) GetEmailById(ctx context.Context, id string) (*store.EmailWithId, error) {
+	var source string
+	err := sqliteStore.db.QueryRowContext(ctx, `SELECT source FROM emails WHERE id = ?`, id).Scan(&source)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %v", store.ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseSource(id, source)
+}
+
+// GetEmailByMessageId returns the email that has the given message id. If more than one
+// email has it, the first one by id is returned.
+func (sqliteStore *SQLiteStore) GetEmailByMessageId(ctx context.Context, messageId string) (*store.EmailWithId, error) {
+	var id, source string
+	err := sqliteStore.db.QueryRowContext(ctx, `SELECT id, source FROM emails WHERE message_id = ? ORDER BY id LIMIT 1`, messageId).Scan(&id, &source)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("message %w: %v", store.ErrNotFound, messageId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseSource(id, source)
+}
+
+// exists returns true if the email that has the given id is in the index.
+func exists(ctx context.Context, tx *sql.Tx, id string) (bool, error) {
+	var count int
+	err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM emails WHERE id = ?`, id).Scan(&count)
+	return count > 0, err
+}
+
+// UpdateEmail replaces the email that has the given id.
+func (sqliteStore *SQLiteStore) UpdateEmail(ctx context.Context, id string, email *email.Email) (*store.EmailWithId, error) {
+	emailWithId := store.NewEmailWithId(id, email)
+	err := sqliteStore.inTransaction(ctx, func(tx *sql.Tx) error {
+		found, err := exists(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%w: %v", store.ErrNotFound, id)
+		}
+		return putEmail(ctx, tx, emailWithId)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return emailWithId, nil
+}
+
+// UpdateEmails replaces a list of emails, by their ids. The emails that aren't
+// in the index are added, as zinc does.
+func (sqliteStore *SQLiteStore) UpdateEmails(ctx context.Context, emails []*store.EmailWithId) ([]*store.EmailWithId, error) {
+	err := sqliteStore.inTransaction(ctx, func(tx *sql.Tx) error {
+		for _, emailWithId := range emails {
+			if err := putEmail(ctx, tx, emailWithId); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return emails, nil
+}
+
+// DeleteEmail deletes the email that has the given id.
+func (sqliteStore *SQLiteStore) DeleteEmail(ctx context.Context, id string) error {
+	return sqliteStore.inTransaction(ctx, func(tx *sql.Tx) error {
+		found, err := exists(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%w: %v", store.ErrNotFound, id)
+		}
+		return deleteEmail(ctx, tx, id)
+	})
+}
+
+// DeleteEmails deletes a list of emails, by their ids. The ids that aren't in the index are ignored.
+func (sqliteStore *SQLiteStore) DeleteEmails(ctx context.Context, ids []string) error {
+	return sqliteStore.inTransaction(ctx, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			if err := deleteEmail(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// took returns the milliseconds since start, as the took of a query response.
+func took(start time.Time) int {
+	return int(time.Since(start).Milliseconds())
+}
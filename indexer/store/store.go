@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/amoralesc/email-indexer/indexer/email"
+)
+
+// ErrNotFound is returned (or wrapped) by a store when the email requested doesn't exist.
+var ErrNotFound = errors.New("id not found")
+
+// EmailStore is a search engine the emails are stored in, which the REST API reads
+// and updates. zinc.ZincService is the default implementation.
+type EmailStore interface {
+	// GetAllEmails returns all emails (paginated).
+	GetAllEmails(ctx context.Context, settings *QuerySettings) (*QueryResponse, error)
+	// GetEmailsBySearchQuery returns all emails that match the given search query (paginated).
+	GetEmailsBySearchQuery(ctx context.Context, searchQuery *SearchQuery, settings *QuerySettings) (*QueryResponse, error)
+	// GetEmailsByQueryString returns all emails that match the given query string (paginated).
+	GetEmailsByQueryString(ctx context.Context, queryString string, settings *QuerySettings) (*QueryResponse, error)
+	// GetEmailById returns the email that has the given id.
+	GetEmailById(ctx context.Context, id string) (*EmailWithId, error)
+	// GetEmailByMessageId returns the email that has the given message id.
+	GetEmailByMessageId(ctx context.Context, messageId string) (*EmailWithId, error)
+	// UpdateEmail replaces the email that has the given id.
+	UpdateEmail(ctx context.Context, id string, email *email.Email) (*EmailWithId, error)
+	// UpdateEmails replaces a list of emails, by their ids.
+	UpdateEmails(ctx context.Context, emails []*EmailWithId) ([]*EmailWithId, error)
+	// DeleteEmail deletes the email that has the given id.
+	DeleteEmail(ctx context.Context, id string) error
+	// DeleteEmails deletes a list of emails, by their ids.
+	DeleteEmails(ctx context.Context, ids []string) error
+}
+
+// EmailIndex is an EmailStore the indexer can upload the emails to.
+// zinc.ZincService is the default implementation.
+type EmailIndex interface {
+	EmailStore
+	// CheckIndex returns true if the emails index exists.
+	CheckIndex(ctx context.Context) (bool, error)
+	// CreateIndex creates the emails index.
+	CreateIndex(ctx context.Context) error
+	// DeleteIndex deletes the emails index.
+	DeleteIndex(ctx context.Context) error
+	// IndexEmails adds a list of emails to the index, replacing the ones with the same ids.
+	// The user state (isRead, isStarred) of the emails already indexed is kept.
+	IndexEmails(ctx context.Context, emails []EmailWithId) error
+}
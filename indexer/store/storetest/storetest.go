@@ -0,0 +1,371 @@
+// Package storetest implements the tests an EmailStore must pass to search and update
+// the emails with the semantics of zinc, against a fixture of a few emails.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/amoralesc/email-indexer/indexer/email"
+	"github.com/amoralesc/email-indexer/indexer/store"
+)
+
+// NewStore returns a store with the given emails, for a test.
+type NewStore func(t *testing.T, emails []store.EmailWithId) store.EmailStore
+
+// Emails returns the emails the tests search. Their addresses are lowercase, as the
+// parser indexes them. e4 has no message id, from, to, cc nor bcc, so it sorts last.
+func Emails() []store.EmailWithId {
+	date := func(month time.Month) time.Time {
+		return time.Date(2001, month, 10, 12, 0, 0, 0, time.UTC)
+	}
+	return []store.EmailWithId{
+		{
+			Id: "e1", MessageId: "<1@x>", Date: date(time.January),
+			From: "alice@enron.com", To: []string{"bob@enron.com", "carl@enron.com"}, Cc: []string{"dan@enron.com"},
+			Subject: "Budget meeting", Body: "The budget for next quarter",
+			Mailbox: "alice", Folder: "inbox", IsStarred: true,
+		},
+		{
+			Id: "e2", MessageId: "<2@x>", Date: date(time.February),
+			From: "bob@enron.com", To: []string{"alice@enron.com"},
+			Subject: "Lunch plans", Body: "Lunch at noon, no budget talk",
+			Mailbox: "bob", Folder: "sent",
+		},
+		{
+			Id: "e3", MessageId: "<3@x>", Date: date(time.March),
+			From: "carl@enron.com", To: []string{"zed@enron.com", "alice@enron.com"}, Bcc: []string{"erin@enron.com"},
+			Subject: "Quarterly report", Body: "Attached is the report",
+			Mailbox: "alice", Folder: "inbox", IsStarred: true,
+		},
+		{
+			Id: "e4", Date: date(time.April),
+			Subject: "Re: budget", Body: "ok",
+			Mailbox: "carl", Folder: "inbox",
+		},
+	}
+}
+
+// Settings returns the query settings of the REST API for the given parameters.
+func Settings(t *testing.T, sortBy string, start int, size int, starredOnly bool) *store.QuerySettings {
+	t.Helper()
+	settings, err := store.NewQuerySettings(sortBy, start, size, starredOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return settings
+}
+
+// Ids returns the ids of the emails of a query response, in order.
+func Ids(resp *store.QueryResponse) []string {
+	result := []string{}
+	for _, emailWithId := range resp.Emails {
+		result = append(result, emailWithId.Id)
+	}
+	return result
+}
+
+// Run runs the tests against the stores returned by newStore. If they are
+// EmailIndexes, IndexEmails is tested too.
+func Run(t *testing.T, newStore NewStore) {
+	t.Run("GetEmailsBySearchQuery", func(t *testing.T) { testGetEmailsBySearchQuery(t, newStore) })
+	t.Run("GetEmailsByQueryString", func(t *testing.T) { testGetEmailsByQueryString(t, newStore) })
+	t.Run("StarredOnly", func(t *testing.T) { testStarredOnly(t, newStore) })
+	t.Run("Sort", func(t *testing.T) { testSort(t, newStore) })
+	t.Run("Pagination", func(t *testing.T) { testPagination(t, newStore) })
+	t.Run("NotFound", func(t *testing.T) { testNotFound(t, newStore) })
+	t.Run("UpdateAndDelete", func(t *testing.T) { testUpdateAndDelete(t, newStore) })
+	t.Run("IndexEmails", func(t *testing.T) { testIndexEmails(t, newStore) })
+}
+
+func testGetEmailsBySearchQuery(t *testing.T, newStore NewStore) {
+	tests := []struct {
+		name  string
+		query store.SearchQuery
+		want  []string
+	}{
+		{"empty query", store.SearchQuery{}, []string{"e1", "e2", "e3", "e4"}},
+		{"from is an exact term", store.SearchQuery{From: "alice@enron.com"}, []string{"e1"}},
+		{"from is lowercased", store.SearchQuery{From: "ALICE@Enron.com"}, []string{"e1"}},
+		{"from doesn't match a part", store.SearchQuery{From: "alice"}, []string{}},
+		{"to matches any of the addresses", store.SearchQuery{To: []string{"alice@enron.com"}}, []string{"e2", "e3"}},
+		{"to must match all", store.SearchQuery{To: []string{"bob@enron.com", "carl@enron.com"}}, []string{"e1"}},
+		{"to doesn't match if one is missing", store.SearchQuery{To: []string{"bob@enron.com", "zed@enron.com"}}, []string{}},
+		{"cc", store.SearchQuery{Cc: []string{"DAN@enron.com"}}, []string{"e1"}},
+		{"bcc", store.SearchQuery{Bcc: []string{"erin@enron.com"}}, []string{"e3"}},
+		{"mailbox", store.SearchQuery{Mailbox: "alice"}, []string{"e1", "e3"}},
+		{"mailbox and folder", store.SearchQuery{Mailbox: "bob", Folder: "sent"}, []string{"e2"}},
+		{"folder is case sensitive", store.SearchQuery{Folder: "Inbox"}, []string{}},
+		{"subject matches a word", store.SearchQuery{SubjectIncludes: "budget"}, []string{"e1", "e4"}},
+		{"subject matches any word", store.SearchQuery{SubjectIncludes: "BUDGET report"}, []string{"e1", "e3", "e4"}},
+		{"subject doesn't match a part of a word", store.SearchQuery{SubjectIncludes: "budg"}, []string{}},
+		{"subject without words", store.SearchQuery{SubjectIncludes: "?!"}, []string{}},
+		{"body", store.SearchQuery{BodyIncludes: "noon"}, []string{"e2"}},
+		{"body excludes", store.SearchQuery{BodyExcludes: "budget"}, []string{"e3", "e4"}},
+		{"body includes and excludes", store.SearchQuery{BodyIncludes: "budget report", BodyExcludes: "lunch"}, []string{"e1", "e3"}},
+		{
+			"date range is inclusive",
+			store.SearchQuery{DateRange: store.DateRange{
+				From: time.Date(2001, time.February, 10, 12, 0, 0, 0, time.UTC),
+				To:   time.Date(2001, time.March, 10, 12, 0, 0, 0, time.UTC),
+			}},
+			[]string{"e2", "e3"},
+		},
+		{
+			"date range without end",
+			store.SearchQuery{DateRange: store.DateRange{From: time.Date(2001, time.March, 1, 0, 0, 0, 0, time.UTC)}},
+			[]string{"e3", "e4"},
+		},
+		{"all fields", store.SearchQuery{Mailbox: "alice", SubjectIncludes: "report", To: []string{"zed@enron.com"}}, []string{"e3"}},
+	}
+
+	emailStore := newStore(t, Emails())
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := emailStore.GetEmailsBySearchQuery(context.Background(), &test.query, Settings(t, "date", 0, 0, false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Ids(resp); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+			if resp.Total != len(test.want) {
+				t.Errorf("got total %v, want %v", resp.Total, len(test.want))
+			}
+		})
+	}
+}
+
+func testGetEmailsByQueryString(t *testing.T, newStore NewStore) {
+	// the syntax every store supports, see the tests of each store for the rest
+	tests := []struct {
+		queryString string
+		want        []string
+	}{
+		{"budget", []string{"e1", "e2", "e4"}},
+		{"lunch report", []string{"e2", "e3"}},
+		{"+budget +meeting", []string{"e1"}},
+		{"budget -lunch", []string{"e1", "e4"}},
+		{"subject:budget", []string{"e1", "e4"}},
+		{"mailbox:alice", []string{"e1", "e3"}},
+		{"+to:alice@enron.com -from:bob@enron.com", []string{"e3"}},
+		{`"next quarter"`, []string{"e1"}},
+		{"quart*", []string{"e1", "e3"}},
+	}
+
+	emailStore := newStore(t, Emails())
+	for _, test := range tests {
+		t.Run(test.queryString, func(t *testing.T) {
+			resp, err := emailStore.GetEmailsByQueryString(context.Background(), test.queryString, Settings(t, "date", 0, 0, false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Ids(resp); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+			if resp.Total != len(test.want) {
+				t.Errorf("got total %v, want %v", resp.Total, len(test.want))
+			}
+		})
+	}
+}
+
+func testStarredOnly(t *testing.T, newStore NewStore) {
+	emailStore := newStore(t, Emails())
+	ctx := context.Background()
+
+	resp, err := emailStore.GetAllEmails(ctx, Settings(t, "date", 0, 0, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := Ids(resp), []string{"e1", "e3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("all: got %v, want %v", got, want)
+	}
+
+	query := &store.SearchQuery{SubjectIncludes: "budget"}
+	resp, err = emailStore.GetEmailsBySearchQuery(ctx, query, Settings(t, "date", 0, 0, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := Ids(resp), []string{"e1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("search query: got %v, want %v", got, want)
+	}
+
+	resp, err = emailStore.GetEmailsByQueryString(ctx, "budget", Settings(t, "date", 0, 0, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := Ids(resp), []string{"e1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("query string: got %v, want %v", got, want)
+	}
+}
+
+func testSort(t *testing.T, newStore NewStore) {
+	// the emails without a value sort last, the ones with many values sort by the lowest
+	// (ascending) or highest (descending), and the ties by the default fields (-date)
+	tests := []struct {
+		sortBy string
+		want   []string
+	}{
+		{"date", []string{"e1", "e2", "e3", "e4"}},
+		{"-date", []string{"e4", "e3", "e2", "e1"}},
+		{"messageId", []string{"e1", "e2", "e3", "e4"}},
+		{"-messageId", []string{"e3", "e2", "e1", "e4"}},
+		{"from", []string{"e1", "e2", "e3", "e4"}},
+		{"-from", []string{"e3", "e2", "e1", "e4"}},
+		{"to", []string{"e3", "e2", "e1", "e4"}},
+		{"-to", []string{"e3", "e1", "e2", "e4"}},
+		{"cc", []string{"e1", "e4", "e3", "e2"}},
+		{"-cc", []string{"e1", "e4", "e3", "e2"}},
+		{"bcc", []string{"e3", "e4", "e2", "e1"}},
+		{"-bcc", []string{"e3", "e4", "e2", "e1"}},
+		{"to,date", []string{"e2", "e3", "e1", "e4"}},
+	}
+
+	emailStore := newStore(t, Emails())
+	for _, test := range tests {
+		t.Run(test.sortBy, func(t *testing.T) {
+			// the sort fields are validated by store.ValidateSortField
+			resp, err := emailStore.GetAllEmails(context.Background(), Settings(t, test.sortBy, 0, 0, false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Ids(resp); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func testPagination(t *testing.T, newStore NewStore) {
+	tests := []struct {
+		start int
+		size  int
+		want  []string
+	}{
+		{0, 2, []string{"e1", "e2"}},
+		{1, 2, []string{"e2", "e3"}},
+		{3, 2, []string{"e4"}},
+		{4, 2, []string{}},
+		{10, 2, []string{}},
+		{0, 0, []string{"e1", "e2", "e3", "e4"}}, // the default size
+	}
+
+	emailStore := newStore(t, Emails())
+	for _, test := range tests {
+		resp, err := emailStore.GetAllEmails(context.Background(), Settings(t, "date", test.start, test.size, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Ids(resp); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("start %v, size %v: got %v, want %v", test.start, test.size, got, test.want)
+		}
+		if resp.Total != 4 {
+			t.Errorf("start %v, size %v: got total %v, want 4", test.start, test.size, resp.Total)
+		}
+	}
+}
+
+func testNotFound(t *testing.T, newStore NewStore) {
+	emailStore := newStore(t, Emails())
+	ctx := context.Background()
+
+	if _, err := emailStore.GetEmailById(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetEmailById: got %v, want ErrNotFound", err)
+	}
+	if _, err := emailStore.GetEmailByMessageId(ctx, "<missing@x>"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetEmailByMessageId: got %v, want ErrNotFound", err)
+	}
+	if _, err := emailStore.UpdateEmail(ctx, "missing", &email.Email{Subject: "new"}); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("UpdateEmail: got %v, want ErrNotFound", err)
+	}
+	if err := emailStore.DeleteEmail(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("DeleteEmail: got %v, want ErrNotFound", err)
+	}
+
+	emailWithId, err := emailStore.GetEmailByMessageId(ctx, "<2@x>")
+	if err != nil || emailWithId.Id != "e2" {
+		t.Errorf("GetEmailByMessageId: got %v, %v, want e2", emailWithId, err)
+	}
+}
+
+func testUpdateAndDelete(t *testing.T, newStore NewStore) {
+	emailStore := newStore(t, Emails())
+	ctx := context.Background()
+
+	updated := &email.Email{
+		MessageId: "<2@x>", Date: time.Date(2001, time.February, 10, 12, 0, 0, 0, time.UTC),
+		From: "bob@enron.com", Subject: "Dinner plans", Body: "Dinner at eight",
+	}
+	if _, err := emailStore.UpdateEmail(ctx, "e2", updated); err != nil {
+		t.Fatalf("UpdateEmail: %v", err)
+	}
+	resp, err := emailStore.GetEmailsByQueryString(ctx, "subject:dinner", Settings(t, "date", 0, 0, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := Ids(resp), []string{"e2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after UpdateEmail: got %v, want %v", got, want)
+	}
+
+	if err := emailStore.DeleteEmail(ctx, "e2"); err != nil {
+		t.Fatalf("DeleteEmail: %v", err)
+	}
+	if _, err := emailStore.GetEmailById(ctx, "e2"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetEmailById after DeleteEmail: got %v, want ErrNotFound", err)
+	}
+	if err := emailStore.DeleteEmails(ctx, []string{"e1", "e3", "missing"}); err != nil {
+		t.Fatalf("DeleteEmails: %v", err)
+	}
+	resp, err = emailStore.GetAllEmails(ctx, Settings(t, "date", 0, 0, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := Ids(resp), []string{"e4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after DeleteEmails: got %v, want %v", got, want)
+	}
+}
+
+func testIndexEmails(t *testing.T, newStore NewStore) {
+	emailIndex, ok := newStore(t, Emails()).(store.EmailIndex)
+	if !ok {
+		t.Skip("the store isn't an EmailIndex")
+	}
+	ctx := context.Background()
+
+	// the user state of e1 (starred, not read) is kept, while the Maildir flags of e5 win
+	reindexed := Emails()[0]
+	reindexed.Subject = "Budget meeting moved"
+	reindexed.IsRead, reindexed.IsStarred = true, false
+	maildir := store.EmailWithId{Id: "e5", Date: time.Date(2001, time.May, 10, 12, 0, 0, 0, time.UTC)}
+	maildir.MaildirUniqueName = "1000.M1P1.host"
+	if err := emailIndex.IndexEmails(ctx, []store.EmailWithId{maildir}); err != nil {
+		t.Fatal(err)
+	}
+	maildir.IsRead, maildir.IsStarred = true, true
+	if err := emailIndex.IndexEmails(ctx, []store.EmailWithId{reindexed, maildir}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id                string
+		subject           string
+		isRead, isStarred bool
+	}{
+		{"e1", "Budget meeting moved", false, true},
+		{"e5", "", true, true},
+	}
+	for _, test := range tests {
+		emailWithId, err := emailIndex.GetEmailById(ctx, test.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if emailWithId.Subject != test.subject || emailWithId.IsRead != test.isRead || emailWithId.IsStarred != test.isStarred {
+			t.Errorf("%v: got subject %q, read %v, starred %v, want %q, %v, %v", test.id,
+				emailWithId.Subject, emailWithId.IsRead, emailWithId.IsStarred, test.subject, test.isRead, test.isStarred)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package zinc
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -12,9 +13,9 @@ const apiDeletePath = "/api/emails/_doc"
 const apiBulkDeletePath = "/api/emails/_bulk"
 
 // DeleteEmail deletes an email from the zinc server.
-func (service *ZincService) DeleteEmail(id string) error {
+func (service *ZincService) DeleteEmail(ctx context.Context, id string) error {
 	// create the request
-	req, err := http.NewRequest("DELETE", service.Url+apiDeletePath+"/"+id, nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", service.Url+apiDeletePath+"/"+id, nil)
 	if err != nil {
 		return err
 	}
@@ -30,14 +31,14 @@ func (service *ZincService) DeleteEmail(id string) error {
 	// check the response
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("zinc server responded with code %v: %v", resp.StatusCode, string(body))
+		return documentError(resp.StatusCode, string(body), id)
 	}
 
 	return nil
 }
 
 // DeleteEmails deletes a list of emails from the zinc server.
-func (service *ZincService) DeleteEmails(ids []string) error {
+func (service *ZincService) DeleteEmails(ctx context.Context, ids []string) error {
 	const deleteTemplate = `{ "delete" : { "_index" : "emails", "_id": "%v" } }` + "\n"
 	var deleteBody string
 
@@ -49,7 +50,7 @@ func (service *ZincService) DeleteEmails(ids []string) error {
 	log.Printf("delete body: %v", deleteBody)
 
 	// create the request
-	req, err := http.NewRequest("POST", service.Url+apiBulkDeletePath, bytes.NewBuffer([]byte(deleteBody)))
+	req, err := http.NewRequestWithContext(ctx, "POST", service.Url+apiBulkDeletePath, bytes.NewBuffer([]byte(deleteBody)))
 	if err != nil {
 		return err
 	}
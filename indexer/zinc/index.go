@@ -2,6 +2,8 @@ package zinc
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,9 +12,9 @@ import (
 const indexPath = "/api/index/"
 
 // CheckIndex checks if the index exists in the zinc server
-func (service *ZincService) CheckIndex() (bool, error) {
+func (service *ZincService) CheckIndex(ctx context.Context) (bool, error) {
 	// create the head request
-	req, err := http.NewRequest("HEAD", service.Url+indexPath+"emails", nil)
+	req, err := http.NewRequestWithContext(ctx, "HEAD", service.Url+indexPath+"emails", nil)
 	if err != nil {
 		return false, err
 	}
@@ -37,101 +39,399 @@ func (service *ZincService) CheckIndex() (bool, error) {
 	return true, nil
 }
 
-// CreateIndex creates an index in the zinc server with a mapping that matches the Email struct
-func (service *ZincService) CreateIndex() error {
-	const emailsIndexMapping = `
-	{
-		"name": "emails",
-		"storage_type": "disk",
-		"mappings": {
-			"properties": {
-				"messageId": {
-					"type": "keyword",
-					"index": true,
-					"store": true,
-					"sortable": true,
-					"aggregatable": false,
-					"highlightable": false
-				},
-				"date": {
-					"type": "date",
-					"format": "2006-01-02T15:04:05Z07:00",
-					"index": true,
-					"store": false,
-					"sortable": true,
-					"aggregatable": true,
-					"highlightable": false
-				},
-				"from": {
-					"type": "keyword",
-					"index": true,
-					"store": true,
-					"sortable": true,
-					"aggregatable": true,
-					"highlightable": false
-				},
-				"to": {
-					"type": "keyword",
-					"index": true,
-					"store": true,
-					"sortable": true,
-					"aggregatable": true,
-					"highlightable": false
-				},
-				"subject": {
-					"type": "text",
-					"index": true,
-					"store": false,
-					"sortable": false,
-					"aggregatable": false,
-					"highlightable": false
-				},
-				"cc": {
-					"type": "keyword",
-					"index": true,
-					"store": true,
-					"sortable": true,
-					"aggregatable": true,
-					"highlightable": false
-				},
-				"bcc": {
-					"type": "keyword",
-					"index": true,
-					"store": true,
-					"sortable": true,
-					"aggregatable": true,
-					"highlightable": false
-				},
-				"body": {
-					"type": "text",
-					"index": true,
-					"store": false,
-					"sortable": false,
-					"aggregatable": false,
-					"highlightable": false
-				},
-				"isRead": {
-					"type": "boolean",
-					"index": true,
-					"store": false,
-					"sortable": false,
-					"aggregatable": false,
-					"highlightable": false
-				},
-				"isStarred": {
-					"type": "boolean",
-					"index": true,
-					"store": false,
-					"sortable": false,
-					"aggregatable": false,
-					"highlightable": false
+// EmailsIndexMapping is the zinc index of the emails, with a mapping that matches the
+// Email struct. Other stores translate its mappings to their own (see blevestore).
+const EmailsIndexMapping = `
+{
+	"name": "emails",
+	"storage_type": "disk",
+	"mappings": {
+		"properties": {
+			"messageId": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": true,
+				"aggregatable": false,
+				"highlightable": false
+			},
+			"date": {
+				"type": "date",
+				"format": "2006-01-02T15:04:05Z07:00",
+				"index": true,
+				"store": false,
+				"sortable": true,
+				"aggregatable": true,
+				"highlightable": false
+			},
+			"dateSource": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": true,
+				"highlightable": false
+			},
+			"from": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": true,
+				"aggregatable": true,
+				"highlightable": false
+			},
+			"to": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": true,
+				"aggregatable": true,
+				"highlightable": false
+			},
+			"subject": {
+				"type": "text",
+				"index": true,
+				"store": false,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false
+			},
+			"cc": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": true,
+				"aggregatable": true,
+				"highlightable": false
+			},
+			"bcc": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": true,
+				"aggregatable": true,
+				"highlightable": false
+			},
+			"sender.name": {
+				"type": "text",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false,
+				"fields": {
+					"keyword": {
+						"type": "keyword",
+						"index": true,
+						"store": false,
+						"sortable": true,
+						"aggregatable": true,
+						"highlightable": false
+					}
+				}
+			},
+			"sender.address": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": true,
+				"aggregatable": true,
+				"highlightable": false
+			},
+			"toRecipients.name": {
+				"type": "text",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false,
+				"fields": {
+					"keyword": {
+						"type": "keyword",
+						"index": true,
+						"store": false,
+						"sortable": true,
+						"aggregatable": true,
+						"highlightable": false
+					}
+				}
+			},
+			"toRecipients.address": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": true,
+				"aggregatable": true,
+				"highlightable": false
+			},
+			"ccRecipients.name": {
+				"type": "text",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false,
+				"fields": {
+					"keyword": {
+						"type": "keyword",
+						"index": true,
+						"store": false,
+						"sortable": true,
+						"aggregatable": true,
+						"highlightable": false
+					}
 				}
+			},
+			"ccRecipients.address": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": true,
+				"aggregatable": true,
+				"highlightable": false
+			},
+			"bccRecipients.name": {
+				"type": "text",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false,
+				"fields": {
+					"keyword": {
+						"type": "keyword",
+						"index": true,
+						"store": false,
+						"sortable": true,
+						"aggregatable": true,
+						"highlightable": false
+					}
+				}
+			},
+			"bccRecipients.address": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": true,
+				"aggregatable": true,
+				"highlightable": false
+			},
+			"body": {
+				"type": "text",
+				"index": true,
+				"store": false,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false
+			},
+			"attachments.filename": {
+				"type": "text",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false
+			},
+			"attachments.contentType": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": true,
+				"highlightable": false
+			},
+			"attachments.size": {
+				"type": "numeric",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false
+			},
+			"attachments.hash": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false
+			},
+			"xFrom": {
+				"type": "text",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false
+			},
+			"xTo": {
+				"type": "text",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false
+			},
+			"xCc": {
+				"type": "text",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false
+			},
+			"xBcc": {
+				"type": "text",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false
+			},
+			"xFolder": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": true,
+				"highlightable": false
+			},
+			"xOrigin": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": true,
+				"highlightable": false
+			},
+			"xFileName": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": true,
+				"highlightable": false
+			},
+			"sourcePath": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": true,
+				"aggregatable": false,
+				"highlightable": false
+			},
+			"sourceArchive": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": true,
+				"highlightable": false
+			},
+			"sourceOffset": {
+				"type": "numeric",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false
+			},
+			"mailbox": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": true,
+				"aggregatable": true,
+				"highlightable": false
+			},
+			"folder": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": true,
+				"aggregatable": true,
+				"highlightable": false
+			},
+			"maildirUniqueName": {
+				"type": "keyword",
+				"index": true,
+				"store": true,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false
+			},
+			"isRead": {
+				"type": "boolean",
+				"index": true,
+				"store": false,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false
+			},
+			"isStarred": {
+				"type": "boolean",
+				"index": true,
+				"store": false,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false
+			},
+			"isReplied": {
+				"type": "boolean",
+				"index": true,
+				"store": false,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false
+			},
+			"isTrashed": {
+				"type": "boolean",
+				"index": true,
+				"store": false,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false
+			},
+			"isDraft": {
+				"type": "boolean",
+				"index": true,
+				"store": false,
+				"sortable": false,
+				"aggregatable": false,
+				"highlightable": false
 			}
 		}
-	}`
+	}
+}`
+
+// IndexProperty is a field of the mapping of a zinc index.
+type IndexProperty struct {
+	Type         string                   `json:"type"`
+	Index        bool                     `json:"index"`
+	Store        bool                     `json:"store"`
+	Sortable     bool                     `json:"sortable"`
+	Aggregatable bool                     `json:"aggregatable"`
+	Fields       map[string]IndexProperty `json:"fields"` // the sub-fields, like sender.name.keyword
+}
+
+// EmailsIndexProperties returns the fields of the mapping of EmailsIndexMapping, by name.
+// The names of nested fields are separated by dots (like sender.name).
+func EmailsIndexProperties() (map[string]IndexProperty, error) {
+	var index struct {
+		Mappings struct {
+			Properties map[string]IndexProperty `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.Unmarshal([]byte(EmailsIndexMapping), &index); err != nil {
+		return nil, fmt.Errorf("invalid emails index mapping: %w", err)
+	}
+	return index.Mappings.Properties, nil
+}
 
+// CreateIndex creates an index in the zinc server with a mapping that matches the Email struct
+func (service *ZincService) CreateIndex(ctx context.Context) error {
 	// create the post request
-	req, err := http.NewRequest("POST", service.Url+indexPath, bytes.NewReader([]byte(emailsIndexMapping)))
+	req, err := http.NewRequestWithContext(ctx, "POST", service.Url+indexPath, bytes.NewReader([]byte(EmailsIndexMapping)))
 	if err != nil {
 		return err
 	}
@@ -155,9 +455,9 @@ func (service *ZincService) CreateIndex() error {
 }
 
 // DeleteIndex deletes the emails index from the zinc server
-func (service *ZincService) DeleteIndex() error {
+func (service *ZincService) DeleteIndex(ctx context.Context) error {
 	// create the delete request
-	req, err := http.NewRequest("DELETE", service.Url+indexPath+"emails", nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", service.Url+indexPath+"emails", nil)
 	if err != nil {
 		return err
 	}
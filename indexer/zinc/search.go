@@ -2,12 +2,14 @@ package zinc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
-	"time"
+
+	"github.com/amoralesc/email-indexer/indexer/store"
 )
 
 const (
@@ -15,31 +17,9 @@ const (
 	apiDocumentPath = "/api/emails/_doc"
 )
 
-// EmailWithId is the returned email format from the zinc server.
-type EmailWithId struct {
-	Id        string    `json:"_id"`
-	MessageId string    `json:"messageId"`
-	Date      time.Time `json:"date"`
-	From      string    `json:"from"`
-	To        []string  `json:"to"`
-	Cc        []string  `json:"cc"`
-	Bcc       []string  `json:"bcc"`
-	Subject   string    `json:"subject"`
-	Body      string    `json:"body"`
-	IsRead    bool      `json:"isRead"`
-	IsStarred bool      `json:"isStarred"`
-}
-
-// QueryResponse is the response from the zinc server to a query.
-type QueryResponse struct {
-	Total  int           `json:"total"`  // Total number of emails that match the query (not the number of emails returned)
-	Took   int           `json:"took"`   // Time it took to execute the query
-	Emails []EmailWithId `json:"emails"` // Emails that match the query (paginated)
-}
-
 // parseQueryResponse parses the body response from the zinc server
-// into a QueryResponse struct.
-func (service *ZincService) parseQueryResponse(body []byte) (*QueryResponse, error) {
+// into a store.QueryResponse struct.
+func (service *ZincService) parseQueryResponse(body []byte) (*store.QueryResponse, error) {
 	// parse the response
 	var resp struct {
 		Took int `json:"took"`
@@ -48,8 +28,8 @@ func (service *ZincService) parseQueryResponse(body []byte) (*QueryResponse, err
 				Value int `json:"value"`
 			} `json:"total"`
 			Hits []struct {
-				Id     string      `json:"_id"`
-				Source EmailWithId `json:"_source"`
+				Id     string            `json:"_id"`
+				Source store.EmailWithId `json:"_source"`
 			} `json:"hits"`
 		} `json:"hits"`
 	}
@@ -59,8 +39,8 @@ func (service *ZincService) parseQueryResponse(body []byte) (*QueryResponse, err
 	}
 
 	// extract the emails
-	emails := func() []EmailWithId {
-		emails := make([]EmailWithId, len(resp.Hits.Hits))
+	emails := func() []store.EmailWithId {
+		emails := make([]store.EmailWithId, len(resp.Hits.Hits))
 		for i, hit := range resp.Hits.Hits {
 			emails[i] = hit.Source
 			emails[i].Id = hit.Id
@@ -68,7 +48,7 @@ func (service *ZincService) parseQueryResponse(body []byte) (*QueryResponse, err
 		return emails
 	}()
 
-	return &QueryResponse{
+	return &store.QueryResponse{
 		Total:  resp.Hits.Total.Value,
 		Took:   resp.Took,
 		Emails: emails,
@@ -76,9 +56,9 @@ func (service *ZincService) parseQueryResponse(body []byte) (*QueryResponse, err
 }
 
 // sendQuery sends a query to the zinc server. It returns the emails that match the query.
-func (service *ZincService) sendQuery(query string) (*QueryResponse, error) {
+func (service *ZincService) sendQuery(ctx context.Context, query string) (*store.QueryResponse, error) {
 	// create the request
-	req, err := http.NewRequest("POST", service.Url+esSearchPath, bytes.NewBuffer([]byte(query)))
+	req, err := http.NewRequestWithContext(ctx, "POST", service.Url+esSearchPath, bytes.NewBuffer([]byte(query)))
 	if err != nil {
 		return nil, err
 	}
@@ -107,7 +87,7 @@ func (service *ZincService) sendQuery(query string) (*QueryResponse, error) {
 }
 
 // GetAllEmails returns all emails from the zinc server (paginated).
-func (service *ZincService) GetAllEmails(settings *QuerySettings) (*QueryResponse, error) {
+func (service *ZincService) GetAllEmails(ctx context.Context, settings *store.QuerySettings) (*store.QueryResponse, error) {
 	// create the query template
 	const queryTemplate = `
 	{
@@ -125,18 +105,18 @@ func (service *ZincService) GetAllEmails(settings *QuerySettings) (*QueryRespons
 
 	var filter = `, "filter": [ %v ]`
 	if settings.StarredOnly {
-		filter = fmt.Sprintf(filter, settings.ParseStarredFilter())
+		filter = fmt.Sprintf(filter, parseStarredFilter(settings))
 	} else {
 		filter = ""
 	}
 
-	query := fmt.Sprintf(queryTemplate, filter, settings.ParseQuerySettings())
+	query := fmt.Sprintf(queryTemplate, filter, parseQuerySettings(settings))
 
-	return service.sendQuery(query)
+	return service.sendQuery(ctx, query)
 }
 
 // GetEmailsBySearchQuery returns all emails that match the given search query (paginated).
-func (service *ZincService) GetEmailsBySearchQuery(searchQuery *SearchQuery, settings *QuerySettings) (*QueryResponse, error) {
+func (service *ZincService) GetEmailsBySearchQuery(ctx context.Context, searchQuery *store.SearchQuery, settings *store.QuerySettings) (*store.QueryResponse, error) {
 	// create the query template
 	const queryTemplate = `
 	{
@@ -153,17 +133,24 @@ func (service *ZincService) GetEmailsBySearchQuery(searchQuery *SearchQuery, set
 
 	// parse the must parameters
 	var mustParameters []string
+	// addresses are indexed in lowercase
 	if searchQuery.From != "" {
-		mustParameters = append(mustParameters, parseExactMatchParameter("from", searchQuery.From))
+		mustParameters = append(mustParameters, parseExactMatchParameter("from", strings.ToLower(searchQuery.From)))
 	}
 	if len(searchQuery.To) > 0 {
-		mustParameters = append(mustParameters, parseMultipleExactMatchParameter("to", searchQuery.To))
+		mustParameters = append(mustParameters, parseMultipleExactMatchParameter("to", lowercaseAll(searchQuery.To)))
 	}
 	if len(searchQuery.Cc) > 0 {
-		mustParameters = append(mustParameters, parseMultipleExactMatchParameter("cc", searchQuery.Cc))
+		mustParameters = append(mustParameters, parseMultipleExactMatchParameter("cc", lowercaseAll(searchQuery.Cc)))
 	}
 	if len(searchQuery.Bcc) > 0 {
-		mustParameters = append(mustParameters, parseMultipleExactMatchParameter("bcc", searchQuery.Bcc))
+		mustParameters = append(mustParameters, parseMultipleExactMatchParameter("bcc", lowercaseAll(searchQuery.Bcc)))
+	}
+	if searchQuery.Mailbox != "" {
+		mustParameters = append(mustParameters, parseExactMatchParameter("mailbox", searchQuery.Mailbox))
+	}
+	if searchQuery.Folder != "" {
+		mustParameters = append(mustParameters, parseExactMatchParameter("folder", searchQuery.Folder))
 	}
 	if searchQuery.SubjectIncludes != "" {
 		mustParameters = append(mustParameters, parseMatchTextParameter("subject", searchQuery.SubjectIncludes))
@@ -180,18 +167,18 @@ func (service *ZincService) GetEmailsBySearchQuery(searchQuery *SearchQuery, set
 	var filterParameters []string
 	filterParameters = append(filterParameters, parseDateRangeParameter(searchQuery.DateRange))
 	if settings.StarredOnly {
-		filterParameters = append(filterParameters, settings.ParseStarredFilter())
+		filterParameters = append(filterParameters, parseStarredFilter(settings))
 	}
 
-	query := fmt.Sprintf(queryTemplate, strings.Join(mustParameters, ", "), mustNotParameters, strings.Join(filterParameters, ", "), settings.ParseQuerySettings())
+	query := fmt.Sprintf(queryTemplate, strings.Join(mustParameters, ", "), mustNotParameters, strings.Join(filterParameters, ", "), parseQuerySettings(settings))
 
-	return service.sendQuery(query)
+	return service.sendQuery(ctx, query)
 }
 
 // GetEmailsByQueryString returns all emails that match the given query string (paginated).
 // A query string is a string composed of query language syntax. For example:
 // "query string +other word +content:test"
-func (service *ZincService) GetEmailsByQueryString(queryString string, settings *QuerySettings) (*QueryResponse, error) {
+func (service *ZincService) GetEmailsByQueryString(ctx context.Context, queryString string, settings *store.QuerySettings) (*store.QueryResponse, error) {
 	// create the query template
 	const queryTemplate = `
 	{
@@ -209,18 +196,18 @@ func (service *ZincService) GetEmailsByQueryString(queryString string, settings
 
 	var filter = `, "filter": [ %v ]`
 	if settings.StarredOnly {
-		filter = fmt.Sprintf(filter, settings.ParseStarredFilter())
+		filter = fmt.Sprintf(filter, parseStarredFilter(settings))
 	} else {
 		filter = ""
 	}
 
-	query := fmt.Sprintf(queryTemplate, queryString, filter, settings.ParseQuerySettings())
+	query := fmt.Sprintf(queryTemplate, queryString, filter, parseQuerySettings(settings))
 
-	return service.sendQuery(query)
+	return service.sendQuery(ctx, query)
 }
 
 // GetEmailByMessageId returns the email that has the given message id.
-func (service *ZincService) GetEmailByMessageId(messageId string) (*EmailWithId, error) {
+func (service *ZincService) GetEmailByMessageId(ctx context.Context, messageId string) (*store.EmailWithId, error) {
 	// create the query template
 	const queryTemplate = `
 	{
@@ -233,21 +220,21 @@ func (service *ZincService) GetEmailByMessageId(messageId string) (*EmailWithId,
 	`
 	query := fmt.Sprintf(queryTemplate, parseExactMatchParameter("messageId", messageId))
 
-	queryResponse, err := service.sendQuery(query)
+	queryResponse, err := service.sendQuery(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 	if len(queryResponse.Emails) == 0 {
-		return nil, fmt.Errorf("message id not found %v", messageId)
+		return nil, fmt.Errorf("message %w: %v", store.ErrNotFound, messageId)
 	}
 
 	return &queryResponse.Emails[0], nil
 }
 
 // GetEmailById returns the email that has the given _id (zinc id).
-func (service *ZincService) GetEmailById(id string) (*EmailWithId, error) {
+func (service *ZincService) GetEmailById(ctx context.Context, id string) (*store.EmailWithId, error) {
 	// create the request
-	req, err := http.NewRequest("GET", service.Url+apiDocumentPath+"/"+id, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", service.Url+apiDocumentPath+"/"+id, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -262,13 +249,13 @@ func (service *ZincService) GetEmailById(id string) (*EmailWithId, error) {
 	// check the response
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("zinc server responded with code %v: %v", resp.StatusCode, string(body))
+		return nil, documentError(resp.StatusCode, string(body), id)
 	}
 
 	// parse the response
 	var respStruct struct {
-		Id     string      `json:"_id"`
-		Source EmailWithId `json:"_source"`
+		Id     string            `json:"_id"`
+		Source store.EmailWithId `json:"_source"`
 	}
 
 	err = json.Unmarshal(body, &respStruct)
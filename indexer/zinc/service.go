@@ -1,5 +1,13 @@
 package zinc
 
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/amoralesc/email-indexer/indexer/store"
+)
+
 // ZincService is a service that interacts with the zinc server.
 type ZincService struct {
 	Url      string
@@ -16,10 +24,15 @@ func NewZincService(url, user, password string) *ZincService {
 	}
 }
 
-// StartZincService starts the zinc service singleton.
-func StartZincService(url, user, password string) {
-	Service = NewZincService(url, user, password)
-}
+// ZincService is the default store (and index) of the emails.
+var _ store.EmailIndex = (*ZincService)(nil)
 
-// ZincService Singleton
-var Service *ZincService
+// documentError returns the error of a request about the document with the given id that
+// zinc responded with a status code other than 200. If the document doesn't exist, it wraps
+// store.ErrNotFound (zinc responds with the message "id not found").
+func documentError(statusCode int, body string, id string) error {
+	if statusCode == http.StatusNotFound || strings.Contains(body, "id not found") {
+		return fmt.Errorf("%w: %v", store.ErrNotFound, id)
+	}
+	return fmt.Errorf("zinc server responded with code %v: %v", statusCode, body)
+}
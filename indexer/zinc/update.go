@@ -2,6 +2,7 @@ package zinc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,20 +10,21 @@ import (
 	"net/http"
 
 	"github.com/amoralesc/email-indexer/indexer/email"
+	"github.com/amoralesc/email-indexer/indexer/store"
 )
 
 const apiUpdatePath = "/api/emails/_update"
 const apiMultiUpdatePath = "/api/emails/_multi"
 
 // UpdateEmail updates an email in the zinc server.
-func (service *ZincService) UpdateEmail(id string, email *email.Email) (*EmailWithId, error) {
+func (service *ZincService) UpdateEmail(ctx context.Context, id string, email *email.Email) (*store.EmailWithId, error) {
 	jsonBytes, err := json.Marshal(*email)
 	if err != nil {
 		return nil, err
 	}
 
 	// create the request
-	req, err := http.NewRequest("POST", service.Url+apiUpdatePath+"/"+id, bytes.NewReader(jsonBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", service.Url+apiUpdatePath+"/"+id, bytes.NewReader(jsonBytes))
 	if err != nil {
 		return nil, err
 	}
@@ -38,44 +40,29 @@ func (service *ZincService) UpdateEmail(id string, email *email.Email) (*EmailWi
 	// check the response
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("zinc server responded with code %v: %v", resp.StatusCode, string(body))
-	}
-
-	// Create EmailWithId from email
-	EmailWithId := EmailWithId{
-		Id:        id,
-		MessageId: email.MessageId,
-		Date:      email.Date,
-		From:      email.From,
-		To:        email.To,
-		Cc:        email.Cc,
-		Bcc:       email.Bcc,
-		Subject:   email.Subject,
-		Body:      email.Body,
-		IsRead:    email.IsRead,
-		IsStarred: email.IsStarred,
+		return nil, documentError(resp.StatusCode, string(body), id)
 	}
 
-	return &EmailWithId, nil
+	return store.NewEmailWithId(id, email), nil
 }
 
 // UpdateEmails updates a list of emails in the zinc server.
-func (service *ZincService) UpdateEmails(emails []*EmailWithId) ([]*EmailWithId, error) {
-	jsonBytes, err := json.Marshal(emails)
-	if err != nil {
-		return nil, err
+func (service *ZincService) UpdateEmails(ctx context.Context, emails []*store.EmailWithId) ([]*store.EmailWithId, error) {
+	// encode one email per line (ndjson)
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, emailWithId := range emails {
+		if err := encoder.Encode(emailWithId); err != nil {
+			return nil, err
+		}
 	}
-
-	// remove [ ] (first and last char)
-	jsonBytes = jsonBytes[1 : len(jsonBytes)-1]
-	// replace all }, with }\n
-	jsonBytes = bytes.ReplaceAll(jsonBytes, []byte("},"), []byte("}\n"))
+	jsonBytes := buf.Bytes()
 
 	// print the json
 	log.Println(string(jsonBytes))
 
 	// create the request
-	req, err := http.NewRequest("POST", service.Url+apiMultiUpdatePath, bytes.NewReader(jsonBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", service.Url+apiMultiUpdatePath, bytes.NewReader(jsonBytes))
 
 	if err != nil {
 		return nil, err
@@ -2,24 +2,50 @@ package zinc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 
-	"github.com/amoralesc/email-indexer/indexer/email"
+	"github.com/amoralesc/email-indexer/indexer/store"
 )
 
-// BulkEmails is used to upload emails in bulk to the zinc server
+// BulkEmails is used to upload emails in bulk to the zinc server.
+// The records have explicit ids, so uploading an email again replaces it.
 type BulkEmails struct {
-	Index   string        `json:"index"`
-	Records []email.Email `json:"records"`
+	Index   string              `json:"index"`
+	Records []store.EmailWithId `json:"records"`
+}
+
+// UserState is the state of an email set by its user.
+// It's kept when the email is indexed again.
+type UserState struct {
+	IsRead    bool `json:"isRead"`
+	IsStarred bool `json:"isStarred"`
 }
 
 const uploadPath = "/api/_bulkv2"
 
+// ResponseError is returned when the zinc server responds to an upload with an error.
+// Its status code tells whether the request may succeed if it's sent again.
+type ResponseError struct {
+	StatusCode int
+	Body       string
+}
+
+func (err *ResponseError) Error() string {
+	return fmt.Sprintf("zinc server responded with code %v: %v", err.StatusCode, err.Body)
+}
+
+// IsTemporary returns true if the zinc server failed to handle the request (5xx) or
+// asked to slow down (429), rather than rejecting it.
+func (err *ResponseError) IsTemporary() bool {
+	return err.StatusCode >= 500 || err.StatusCode == http.StatusTooManyRequests
+}
+
 // UploadEmails uploads a list of emails to the zinc server
-func UploadEmails(bulk *BulkEmails, auth *ZincAuth) error {
+func UploadEmails(ctx context.Context, bulk *BulkEmails, auth *ZincAuth) error {
 	// convert the struct to JSON
 	jsonBytes, err := json.Marshal(*bulk)
 	if err != nil {
@@ -27,7 +53,7 @@ func UploadEmails(bulk *BulkEmails, auth *ZincAuth) error {
 	}
 
 	// create the post request
-	req, err := http.NewRequest("POST", auth.Url+uploadPath, bytes.NewReader(jsonBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", auth.Url+uploadPath, bytes.NewReader(jsonBytes))
 	if err != nil {
 		return err
 	}
@@ -44,8 +70,81 @@ func UploadEmails(bulk *BulkEmails, auth *ZincAuth) error {
 	// check the response
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("zinc server responded with code %v: %v", resp.StatusCode, string(body))
+		return &ResponseError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	return nil
 }
+
+// GetUserStates returns the user state of the indexed emails with the given ids.
+// Emails that aren't indexed are missing from the returned map.
+func GetUserStates(ctx context.Context, ids []string, auth *ZincAuth) (map[string]UserState, error) {
+	const queryTemplate = `{ "query": { "ids": { "values": %v } }, "_source": [ "isRead", "isStarred" ], "size": %d }`
+
+	idsBytes, err := json.Marshal(ids)
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf(queryTemplate, string(idsBytes), len(ids))
+
+	// create the post request
+	req, err := http.NewRequestWithContext(ctx, "POST", auth.Url+esSearchPath, bytes.NewReader([]byte(query)))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(auth.User, auth.Password)
+	req.Header.Set("Content-Type", "application/json")
+
+	// send the request
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// check the response
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		return nil, &ResponseError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	// parse the response
+	var respStruct struct {
+		Hits struct {
+			Hits []struct {
+				Id     string    `json:"_id"`
+				Source UserState `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(body, &respStruct); err != nil {
+		return nil, fmt.Errorf("error parsing response: %v", err)
+	}
+
+	states := make(map[string]UserState, len(respStruct.Hits.Hits))
+	for _, hit := range respStruct.Hits.Hits {
+		states[hit.Id] = hit.Source
+	}
+	return states, nil
+}
+
+// IndexEmails uploads a list of emails to the zinc server. The user state of the emails
+// that were already indexed is kept, unless their Maildir flags set it (see store.EmailWithId.KeepUserState).
+func (service *ZincService) IndexEmails(ctx context.Context, emails []store.EmailWithId) error {
+	auth := &ZincAuth{Url: service.Url, User: service.User, Password: service.Password}
+	ids := make([]string, len(emails))
+	for i := range emails {
+		ids[i] = emails[i].Id
+	}
+	states, err := GetUserStates(ctx, ids, auth)
+	if err != nil {
+		return err
+	}
+	for i := range emails {
+		if state, ok := states[emails[i].Id]; ok {
+			emails[i].KeepUserState(state.IsRead, state.IsStarred)
+		}
+	}
+
+	return UploadEmails(ctx, &BulkEmails{Index: "emails", Records: emails}, auth)
+}